@@ -2,10 +2,10 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"strconv"
 	"strings"
@@ -23,6 +23,8 @@ const (
 	cmdWord
 	cmdInst
 	cmdReg
+	cmdHeap
+	cmdCore
 	cmdRun
 	cmdRestart
 	cmdHelp
@@ -33,6 +35,8 @@ l, list [N]: List N instructions
 x addr-list: Print words at listed addresses
 i addr [N]: Print N instructions after addr
 r, reg [name-list]: Show content of register(s)
+heap: Show the heap and its break
+core [N]: Show the current core, or switch to core N
 run: Run to end
 rs, restart: Restart the program
 h, help: Show this help message
@@ -64,6 +68,12 @@ var (
 		"k0", "k1",
 		"gp", "sp", "fp", "ra",
 		"PC", "HI", "LO",
+		"BadVAddr", "Count", "Compare", "Status", "Cause", "EPC",
+		"f0", "f1", "f2", "f3", "f4", "f5", "f6", "f7",
+		"f8", "f9", "f10", "f11", "f12", "f13", "f14", "f15",
+		"f16", "f17", "f18", "f19", "f20", "f21", "f22", "f23",
+		"f24", "f25", "f26", "f27", "f28", "f29", "f30", "f31",
+		"FCSR",
 	}
 )
 
@@ -76,7 +86,7 @@ func debug(filename string) {
 	code, err := ioutil.ReadFile(filename)
 	checkFatalErr(err)
 
-	em := mips.NewEmulator()
+	em := newEmulator()
 	err = em.LoadAndStart(code)
 	checkFatalErr(err)
 
@@ -99,7 +109,7 @@ func debug(filename string) {
 		case cmdHelp:
 			fmt.Fprintln(os.Stderr, helpMessage)
 		case cmdRestart:
-			em = mips.NewEmulator()
+			em = newEmulator()
 			err = em.LoadAndStart(code)
 			checkFatalErr(err)
 			cache = nil
@@ -113,6 +123,10 @@ func debug(filename string) {
 			showInst(em, cmd.args.([]int))
 		case cmdReg:
 			showReg(em, cmd.args.([]string))
+		case cmdHeap:
+			showHeap(em)
+		case cmdCore:
+			switchCore(em, cmd.args.([]int))
 		case cmdRun:
 			runToEnd(em)
 		case cmdQuit:
@@ -137,9 +151,18 @@ func step(em *mips.Emulator, args []int) {
 		checkErr(err)
 		addr, err := em.ReadReg("PC")
 		checkErr(err)
+		slot := em.InDelaySlot()
+		core := em.Core()
 		err = em.Step()
 		checkErr(err)
-		fmt.Printf("%#x: %s\n", addr, s)
+		if em.Cores() > 1 {
+			fmt.Printf("[core %d] ", core)
+		}
+		if slot {
+			fmt.Printf("%#x: %s    (delay slot)\n", addr, s)
+		} else {
+			fmt.Printf("%#x: %s\n", addr, s)
+		}
 	}
 }
 
@@ -153,9 +176,9 @@ func showWord(em *mips.Emulator, args []int) {
 		panic("Please specify at least one address")
 	}
 	for _, addr := range args {
-		word, err := em.ReadMemory(addr)
+		word, err := em.ReadMemory(uint32(addr))
 		checkErr(err)
-		fmt.Printf("%#x: %#x(%d)\n", addr, word, word)
+		fmt.Printf("%#x: %#x(%d)\n", addr, word, int32(word))
 	}
 }
 
@@ -174,17 +197,13 @@ func showInst(em *mips.Emulator, args []int) {
 		n = args[1]
 	}
 	for i := 0; i < n; i++ {
-		word, err := em.ReadMemory(addr)
-		checkErr(err)
-		buf := new(bytes.Buffer)
-		err = binary.Write(buf, binary.LittleEndian, uint32(word))
+		word, err := em.ReadMemory(uint32(addr))
 		checkErr(err)
-		s, err := mips.Disassemble(buf.Bytes())
+		s, err := mips.DisassembleWord(word)
 		checkErr(err)
-		buf.Reset()
-		err = binary.Write(buf, binary.BigEndian, uint32(word))
-		checkErr(err)
-		fmt.Printf("%#x: % x    %s\n", addr, buf.Bytes(), string(s))
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, word)
+		fmt.Printf("%#x: % x    %s\n", addr, b, string(s))
 		addr += 4
 	}
 }
@@ -196,20 +215,40 @@ func showReg(em *mips.Emulator, args []string) {
 		}
 	}()
 	if len(args) == 0 {
-		for _, reg := range registers {
-			word, err := em.ReadReg(reg)
-			checkErr(err)
-			fmt.Printf("%s: %#x(%d)\n", reg, word, word)
-		}
-		return
+		args = registers
 	}
 	for _, reg := range args {
 		word, err := em.ReadReg(reg)
 		checkErr(err)
-		fmt.Printf("%s: %#x(%d)\n", reg, word, word)
+		if isFPRegister(reg) {
+			// Show the single-precision value of FP registers
+			fmt.Printf("%s: %#x(%v)\n", reg, word, math.Float32frombits(word))
+			continue
+		}
+		fmt.Printf("%s: %#x(%d)\n", reg, word, int32(word))
 	}
 }
 
+func showHeap(em *mips.Emulator) {
+	start, brk := em.Heap()
+	fmt.Printf("heap: %#x - %#x (%d bytes)\n", start, brk, brk-start)
+}
+
+func switchCore(em *mips.Emulator, args []int) {
+	if len(args) > 0 {
+		if err := em.SelectCore(args[0]); err != nil {
+			logger.Println(err)
+			return
+		}
+	}
+	fmt.Printf("core %d of %d\n", em.Core(), em.Cores())
+}
+
+func isFPRegister(reg string) bool {
+	n, err := strconv.Atoi(strings.TrimPrefix(reg, "f"))
+	return strings.HasPrefix(reg, "f") && err == nil && n >= 0 && n < 32
+}
+
 func runToEnd(em *mips.Emulator) {
 	err := em.Step()
 	for ; err == nil; err = em.Step() {
@@ -234,7 +273,11 @@ func listSrc(em *mips.Emulator, args []int) {
 	checkErr(err)
 
 	for i, line := range lines {
-		fmt.Printf("%#x: %s\n", addr+i<<2, line)
+		if i == 0 && em.InDelaySlot() {
+			fmt.Printf("%#x: %s    (delay slot)\n", addr, line)
+			continue
+		}
+		fmt.Printf("%#x: %s\n", addr+uint32(i<<2), line)
 	}
 }
 
@@ -263,6 +306,10 @@ func scanCommand() (cmd Command) {
 		cmd.cmd = cmdStep
 	case "r", "reg":
 		cmd.cmd = cmdReg
+	case "heap":
+		cmd.cmd = cmdHeap
+	case "core":
+		cmd.cmd = cmdCore
 	case "x":
 		cmd.cmd = cmdWord
 	case "i":
@@ -281,10 +328,10 @@ func scanCommand() (cmd Command) {
 
 	args := tokens[1:]
 	switch cmd.cmd {
-	case cmdWord, cmdStep, cmdListSrc, cmdInst:
+	case cmdWord, cmdStep, cmdListSrc, cmdInst, cmdCore:
 		cmd.args = []int{}
 		for _, a := range args {
-			n, err := strconv.ParseInt(a, 0, 32)
+			n, err := strconv.ParseInt(a, 0, 64)
 			checkErr(err)
 			cmd.args = append(cmd.args.([]int), int(n))
 		}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/fanyang01/vmips/mips"
 )
@@ -29,7 +33,31 @@ var (
 	asmRunM = flag.Bool("r", false, "Assemble and run")
 	debugM  = flag.Bool("g", false, "Debug mode")
 	outFile = flag.String("o", "a.out", "Output file")
+	branchD = flag.Bool("b", false, "Enable branch delay slots")
+	loadD   = flag.Bool("l", false, "Enable load delay slots")
+	textW   = flag.Bool("w", false, "Allow programs to write to their text")
+	fsRoot  = flag.String("root", "", "Directory for file syscalls, none by default")
+	fsRW    = flag.Bool("rw", false, "Allow file syscalls to write to the directory")
+	layoutN = flag.String("layout", "default", "Memory layout: default, mars or spim")
+	heapMax = flag.Uint("heap", 0, "Maximum heap size in bytes, 0 for no limit")
+	mmio    = flag.Bool("mmio", false, "Map the SPIM console at 0xffff0000")
+	endianN = flag.String("endian", "little", "Byte order of assembled code: little or big")
+	dispN   = flag.String("display", "", "Show memory on a bitmap display of WxH pixels, e.g. 512x256")
+	dispU   = flag.Int("unit", 1, "Pixels of a side of a unit of the bitmap display")
+	dispA   = flag.Uint("display-base", 0x10010000, "Address of the memory the bitmap display shows")
+	pngFile = flag.String("png", "", "Save the bitmap display to a PNG file at exit")
+	ansiD   = flag.Bool("ansi", false, "Draw the bitmap display to the terminal at exit")
+	frames  = flag.Int("frames", 0, "Also save or draw the bitmap display every N frames")
+	cores   = flag.Int("cores", 1, "Number of cores")
+	seed    = flag.Int64("seed", 0, "Seed of the scheduler of cores")
+	quantum = flag.Int("quantum", 100, "Instructions a core runs before the scheduler switches cores")
+	maxInst = flag.Uint64("max-insts", 0, "Stop after executing N instructions, 0 for no limit")
+	maxMem  = flag.Int("max-mem", 0, "Maximum memory allocated in bytes, 0 for no limit")
+	maxOut  = flag.Int64("max-output", 0, "Maximum bytes written to the output and files, 0 for no limit")
+	maxIn   = flag.Int64("max-input", 0, "Maximum input read in bytes, 0 for no limit")
+	timeout = flag.Duration("timeout", 0, "Stop the program after the duration, 0 for none")
 	logger  = log.New(os.Stderr, "", 0)
+	display *mips.Display
 )
 
 func main() {
@@ -61,6 +89,9 @@ func asmFile(filename string) {
 	defer w.Flush()
 
 	assembler := mips.NewAssembler(f)
+	err = assembler.SetLayout(memoryLayout())
+	checkFatalErr(err)
+	assembler.SetByteOrder(byteOrder())
 	s, err := assembler.Assemble()
 	checkFatalErr(err)
 	_, err = w.Write(s)
@@ -83,12 +114,7 @@ func runFile(filename string) {
 	s, err := ioutil.ReadFile(filename)
 	checkFatalErr(err)
 
-	em := mips.NewEmulator()
-	err = em.LoadAndRun(s)
-	checkFatalErr(err)
-
-	err = em.Wait()
-	checkFatalErr(err)
+	run(s)
 }
 
 func asmAndRun(filename string) {
@@ -97,15 +123,142 @@ func asmAndRun(filename string) {
 	defer f.Close()
 
 	assembler := mips.NewAssembler(f)
+	err = assembler.SetLayout(memoryLayout())
+	checkFatalErr(err)
+	assembler.SetByteOrder(byteOrder())
 	s, err := assembler.Assemble()
 	checkFatalErr(err)
 
-	em := mips.NewEmulator()
-	err = em.LoadAndRun(s)
-	checkFatalErr(err)
+	run(s)
+}
+
+// run runs object code, exiting with the code the program passed to exit2
+func run(code []byte) {
+	em := newEmulator()
+	checkFatalErr(em.Load(code))
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	r := em.Execute(ctx)
+	if display != nil {
+		showDisplay(0)
+	}
+	if r.Err != nil {
+		fatalf("%v after %d instructions\n", r.Err, r.Instructions)
+	}
+	if c := em.ExitCode(); c != 0 {
+		os.Exit(c)
+	}
+}
+
+// memoryLayout returns the memory layout selected by flags
+func memoryLayout() mips.MemoryLayout {
+	switch *layoutN {
+	case "default":
+		return mips.DefaultLayout
+	case "mars":
+		return mips.MARSLayout
+	case "spim":
+		return mips.SPIMLayout
+	}
+	fatalf("Unknown memory layout: %s\n", *layoutN)
+	panic("unreachable")
+}
+
+// byteOrder returns the byte order selected by flags
+func byteOrder() binary.ByteOrder {
+	switch *endianN {
+	case "little":
+		return binary.LittleEndian
+	case "big":
+		return binary.BigEndian
+	}
+	fatalf("Unknown byte order: %s\n", *endianN)
+	panic("unreachable")
+}
 
-	err = em.Wait()
+// newEmulator creates an emulator as flags tell, with a new bitmap
+// display if one is selected
+func newEmulator() *mips.Emulator {
+	em := mips.NewEmulator(emulatorOptions()...)
+	if *dispN == "" {
+		return em
+	}
+	var w, h int
+	_, err := fmt.Sscanf(*dispN, "%dx%d", &w, &h)
+	if err != nil {
+		fatalf("Bad display size: %s\n", *dispN)
+	}
+	display, err = mips.NewDisplay(w, h, *dispU)
 	checkFatalErr(err)
+	if *frames > 0 {
+		display.EveryFrames(*frames, showDisplay)
+	}
+	checkFatalErr(em.AttachDisplay(uint32(*dispA), display))
+	return em
+}
+
+// showDisplay saves or draws the bitmap display as flags tell. Frames
+// other than the last one, numbered 0, are saved to files numbered
+// after them.
+func showDisplay(frame int) {
+	if *pngFile != "" {
+		name := *pngFile
+		if frame > 0 {
+			ext := filepath.Ext(name)
+			name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), frame, ext)
+		}
+		f, err := os.Create(name)
+		checkFatalErr(err)
+		err = display.WritePNG(f)
+		f.Close()
+		checkFatalErr(err)
+	}
+	if *ansiD {
+		checkFatalErr(display.WriteANSI(os.Stdout))
+	}
+}
+
+// emulatorOptions returns the emulator options selected by flags
+func emulatorOptions() []mips.Option {
+	opts := []mips.Option{
+		mips.WithLayout(memoryLayout()),
+		mips.WithByteOrder(byteOrder()),
+		mips.WithCores(*cores),
+		mips.WithScheduler(*seed, *quantum),
+	}
+	if *branchD {
+		opts = append(opts, mips.WithBranchDelay())
+	}
+	if *loadD {
+		opts = append(opts, mips.WithLoadDelay())
+	}
+	if *textW {
+		opts = append(opts, mips.WithWritableText())
+	}
+	if *fsRoot != "" {
+		mode := mips.ReadOnly
+		if *fsRW {
+			mode = mips.ReadWrite
+		}
+		opts = append(opts, mips.WithFileSystem(mips.NewDirFS(*fsRoot, mode)))
+	}
+	if *heapMax > 0 {
+		opts = append(opts, mips.WithHeapLimit(uint32(*heapMax)))
+	}
+	if *mmio {
+		opts = append(opts, mips.WithConsole())
+	}
+	opts = append(opts, mips.WithLimits(mips.Limits{
+		Instructions: *maxInst,
+		Memory:       *maxMem,
+		Output:       *maxOut,
+		Input:        *maxIn,
+	}))
+	return opts
 }
 
 func parseMode() Mode {
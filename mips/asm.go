@@ -7,18 +7,21 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 )
 
 type Assembler struct {
 	r           *bufio.Reader
 	items       <-chan parseItem
 	entryOffset int
+	layout      MemoryLayout
+	order       binary.ByteOrder
 }
 
-// Assemble only assembles instructions
+// Assemble only assembles instructions, in little-endian order
 func Assemble(s []byte) ([]byte, error) {
 	input := bytes.NewBuffer(s)
-	items := parse(bufio.NewReader(input))
+	items := parse(bufio.NewReader(input), DefaultLayout)
 	buf := new(bytes.Buffer)
 LOOP:
 	for item := range items {
@@ -28,7 +31,7 @@ LOOP:
 		case itemEOF:
 			break LOOP
 		case itemInst:
-			_, err := buf.Write(asmInst(item))
+			_, err := buf.Write(asmInst(item, binary.LittleEndian))
 			if err != nil {
 				return nil, err
 			}
@@ -41,17 +44,34 @@ LOOP:
 
 func NewAssembler(r io.Reader) *Assembler {
 	return &Assembler{
-		r: bufio.NewReader(r),
+		r:      bufio.NewReader(r),
+		layout: DefaultLayout,
+		order:  binary.LittleEndian,
 	}
 }
 
+// SetLayout makes the assembler place code and data as l tells
+func (a *Assembler) SetLayout(l MemoryLayout) error {
+	if err := l.validate(); err != nil {
+		return err
+	}
+	a.layout = l
+	return nil
+}
+
+// SetByteOrder sets the byte order of the object, which is little-endian
+// by default
+func (a *Assembler) SetByteOrder(order binary.ByteOrder) {
+	a.order = order
+}
+
 func (a *Assembler) Assemble() (b []byte, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("runtime panic: %v", r)
 		}
 	}()
-	a.items = parse(a.r)
+	a.items = parse(a.r, a.layout)
 	b, err = a.assemble()
 	return
 }
@@ -60,6 +80,8 @@ func (a *Assembler) assemble() ([]byte, error) {
 	var section **bytes.Buffer
 	textSection := new(bytes.Buffer)
 	dataSection := new(bytes.Buffer)
+	ktextSection := new(bytes.Buffer)
+	kdataSection := new(bytes.Buffer)
 	section = &textSection
 LOOP:
 	for item := range a.items {
@@ -69,7 +91,7 @@ LOOP:
 		case itemEOF:
 			break LOOP
 		case itemInst:
-			_, err := (*section).Write(asmInst(item))
+			_, err := (*section).Write(asmInst(item, a.order))
 			if err != nil {
 				return nil, err
 			}
@@ -79,38 +101,69 @@ LOOP:
 				section = &textSection
 			case "data":
 				section = &dataSection
+			case "ktext":
+				section = &ktextSection
+				if item.data != nil {
+					pad := item.data.(int) - KTEXT_ADDRESS - ktextSection.Len()
+					ktextSection.Write(make([]byte, pad))
+				}
+			case "kdata":
+				section = &kdataSection
+				if item.data != nil {
+					pad := item.data.(int) - KDATA_ADDRESS - kdataSection.Len()
+					kdataSection.Write(make([]byte, pad))
+				}
 			case "globl":
-				a.entryOffset = item.address - TEXT_ADDRESS
+				a.entryOffset = item.address - int(a.layout.Text)
+			case "set":
+				// Handled by the parser
 			default:
-				_, err := (*section).Write(asmDir(item))
+				_, err := (*section).Write(asmDir(item, a.order))
 				if err != nil {
 					return nil, err
 				}
 			}
 		}
 	}
-	b := []byte(fmt.Sprintf("text:%d,data:%d,main:%d\n",
-		0, textSection.Len(), a.entryOffset))
+	h := &objectHeader{
+		text:     0,
+		data:     textSection.Len(),
+		main:     a.entryOffset,
+		textAddr: a.layout.Text,
+		dataAddr: a.layout.Data,
+		order:    a.order,
+	}
+	if ktextSection.Len() > 0 || kdataSection.Len() > 0 {
+		h.kernel = true
+		h.ktext = h.data + dataSection.Len()
+		h.kdata = h.ktext + ktextSection.Len()
+	}
+	b := []byte(h.String() + "\n")
 	b = append(b, textSection.Bytes()...)
 	b = append(b, dataSection.Bytes()...)
+	b = append(b, ktextSection.Bytes()...)
+	b = append(b, kdataSection.Bytes()...)
 	return b, nil
 }
 
-func asmInst(item parseItem) []byte {
-	var raw int
+func asmInst(item parseItem, order binary.ByteOrder) []byte {
 	inst := instructionTable[item.instruction]
-	raw |= inst.opcode << 26
-	if inst.typ == "R" {
-		raw |= inst.funct
-	}
+	raw := int(inst.encoding())
 	for i, f := range inst.formats {
 		switch f {
 		case fmtRegS:
-			raw |= registerTable[item.registers[i][1:]] << 21
+			raw |= regNumber(item.registers[i]) << 21
 		case fmtRegT:
-			raw |= registerTable[item.registers[i][1:]] << 16
+			raw |= regNumber(item.registers[i]) << 16
 		case fmtRegD:
-			raw |= registerTable[item.registers[i][1:]] << 11
+			raw |= regNumber(item.registers[i]) << 11
+		case fmtFd:
+			raw |= regNumber(item.registers[i]) << 6
+		case fmtCode:
+			if item.imme < 0 || item.imme >= 1<<10 {
+				panic(fmt.Sprintf("trap code %d out of range", item.imme))
+			}
+			raw |= item.imme << 6
 		case fmtShamt:
 			if item.imme < 0 || item.imme > 31 {
 				panic(fmt.Sprintf("shift amount %d out of range", item.imme))
@@ -127,19 +180,28 @@ func asmInst(item parseItem) []byte {
 				panic(fmt.Sprintf("immediate number %d out of range", item.imme))
 			}
 			raw |= (item.imme & 0x3FFFFFF)
+		case fmtExtSize:
+			if item.imme2 < 1 || item.imme+item.imme2 > 32 {
+				panic(fmt.Sprintf("bit field %d, %d out of range",
+					item.imme, item.imme2))
+			}
+			raw |= (item.imme2 - 1) << 11
+		case fmtInsSize:
+			if item.imme2 < 1 || item.imme+item.imme2 > 32 {
+				panic(fmt.Sprintf("bit field %d, %d out of range",
+					item.imme, item.imme2))
+			}
+			raw |= (item.imme + item.imme2 - 1) << 11
 		default:
 			// shouldn't get here
 		}
 	}
-	buf := new(bytes.Buffer)
-	err := binary.Write(buf, binary.LittleEndian, uint32(raw))
-	if err != nil {
-		panic(err.Error())
-	}
-	return buf.Bytes()
+	b := make([]byte, 4)
+	order.PutUint32(b, uint32(raw))
+	return b
 }
 
-func asmDir(item parseItem) []byte {
+func asmDir(item parseItem, order binary.ByteOrder) []byte {
 	switch item.directive {
 	case "space":
 		return make([]byte, item.data.(int))
@@ -158,14 +220,29 @@ func asmDir(item parseItem) []byte {
 	case "half":
 		var s []byte
 		for _, n := range item.data.([]int) {
-			s = append(s, byte(n&0xFF), byte((n>>8)&0xFF))
+			s = append(s, make([]byte, 2)...)
+			order.PutUint16(s[len(s)-2:], uint16(n))
 		}
 		return s
 	case "word":
 		var s []byte
 		for _, n := range item.data.([]int) {
-			s = append(s, byte(n&0xFF), byte((n>>8)&0xFF),
-				byte(n>>16&0xFF), byte((n>>24)&0xFF))
+			s = append(s, make([]byte, 4)...)
+			order.PutUint32(s[len(s)-4:], uint32(n))
+		}
+		return s
+	case "float":
+		var s []byte
+		for _, f := range item.data.([]float64) {
+			s = append(s, make([]byte, 4)...)
+			order.PutUint32(s[len(s)-4:], math.Float32bits(float32(f)))
+		}
+		return s
+	case "double":
+		var s []byte
+		for _, f := range item.data.([]float64) {
+			s = append(s, make([]byte, 8)...)
+			order.PutUint64(s[len(s)-8:], math.Float64bits(f))
 		}
 		return s
 	case "ascii":
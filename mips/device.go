@@ -0,0 +1,243 @@
+package mips
+
+import (
+	"fmt"
+	"io"
+)
+
+// Device is a memory-mapped I/O device. Its registers are words at
+// offsets from the address it is mapped at. Loads of bytes and halfwords
+// read a whole register, and stores of them write only their bytes.
+type Device interface {
+	// Read returns the register at offset, a multiple of 4
+	Read(offset uint32) uint32
+	// Write writes the bits of value selected by mask to the register
+	// at offset, a multiple of 4. Stores of bytes and halfwords select
+	// only their bytes; the other bits of the register are kept.
+	Write(offset uint32, value, mask uint32)
+	// Tick advances the device by one instruction of any core. It
+	// returns the hardware interrupts 0 to 5 the device requests, as a
+	// bit mask; they interrupt core 0.
+	Tick() uint8
+}
+
+type mappedDevice struct {
+	base, size uint32
+	dev        Device
+}
+
+// mapDevice maps d over [addr, addr+size), which must be aligned to words
+// and not overlap segments or other devices.
+func (m *virtualMemory) mapDevice(addr, size uint32, d Device) error {
+	end := uint64(addr) + uint64(size)
+	if addr&3 != 0 || size&3 != 0 || size == 0 || end > 1<<32 {
+		return fmt.Errorf("map device %#x: bad address range", addr)
+	}
+	if m.overlapsSegments(addr, end) {
+		return fmt.Errorf("map device %#x: overlaps memory segments", addr)
+	}
+	for _, md := range m.devices {
+		if uint64(md.base) < end && addr < md.base+md.size {
+			return fmt.Errorf("map device %#x: overlaps the device at %#x", addr, md.base)
+		}
+	}
+	m.devices = append(m.devices, &mappedDevice{addr, size, d})
+	m.last = nil
+	return nil
+}
+
+// overlapsSegments reports whether [addr, end) overlaps the user or
+// kernel segments of the layout
+func (m *virtualMemory) overlapsSegments(addr uint32, end uint64) bool {
+	l := m.layout
+	return addr < l.StackBase+4 && end > uint64(l.Text) ||
+		addr < MAX_KDATA_ADDR && end > KTEXT_ADDRESS
+}
+
+// checkDevices checks that options mapped their devices, and that no
+// device overlaps the segments, as the layout may have changed since the
+// devices were mapped
+func (m *virtualMemory) checkDevices() error {
+	if m.mapErr != nil {
+		return m.mapErr
+	}
+	for _, d := range m.devices {
+		if m.overlapsSegments(d.base, uint64(d.base)+uint64(d.size)) {
+			return fmt.Errorf("device at %#x overlaps memory segments", d.base)
+		}
+	}
+	return nil
+}
+
+// device returns the device mapped at addr, or nil
+func (m *virtualMemory) device(addr uint32) *mappedDevice {
+	for _, d := range m.devices {
+		if addr >= d.base && addr-d.base < d.size {
+			return d
+		}
+	}
+	return nil
+}
+
+// deviceShift returns the position in bits of the n bytes at addr in
+// their register
+func (m *virtualMemory) deviceShift(addr, n uint32) uint32 {
+	if m.bigEndian() {
+		return 8 * m.byteLane(addr+n-1)
+	}
+	return 8 * m.byteLane(addr)
+}
+
+func (m *virtualMemory) readDevice(d *mappedDevice, addr, n uint32) uint32 {
+	w := d.dev.Read((addr - d.base) &^ 3)
+	return w >> m.deviceShift(addr, n) & (0xFFFFFFFF >> (32 - 8*n))
+}
+
+func (m *virtualMemory) writeDevice(d *mappedDevice, addr, n, value uint32) {
+	sh := m.deviceShift(addr, n)
+	mask := uint32(0xFFFFFFFF) >> (32 - 8*n) << sh
+	d.dev.Write((addr-d.base)&^3, value<<sh&mask, mask)
+}
+
+// tick advances the devices and displays by one instruction, and returns
+// the hardware interrupts the devices request
+func (m *virtualMemory) tick() uint8 {
+	var irq uint8
+	for _, d := range m.devices {
+		irq |= d.dev.Tick()
+	}
+	for _, d := range m.displays {
+		d.tick()
+	}
+	return irq
+}
+
+// MapDevice maps d over [addr, addr+size), where no memory is. addr and
+// size must be multiples of 4, and the range must not overlap the user
+// or kernel segments of the layout.
+func (e *Emulator) MapDevice(addr, size uint32, d Device) error {
+	return e.machine.m.mapDevice(addr, size, d)
+}
+
+// WithConsole maps the memory-mapped console of SPIM and MARS at
+// CONSOLE_ADDRESS. It reads the standard input of the program and writes
+// to its standard output.
+func WithConsole() Option {
+	return func(e *Emulator) {
+		m := e.machine.m
+		if err := m.mapDevice(CONSOLE_ADDRESS, consoleSize, &console{m: e.machine}); err != nil && m.mapErr == nil {
+			m.mapErr = err
+		}
+	}
+}
+
+// registers of the console, at CONSOLE_ADDRESS
+const (
+	consoleSize     = 16
+	consoleRecvCtrl = 0x0
+	consoleRecvData = 0x4
+	consoleSendCtrl = 0x8
+	consoleSendData = 0xC
+	// bits of the control registers
+	consoleReady = 1 << 0
+	consoleIE    = 1 << 1
+	// hardware interrupts of the receiver and the transmitter, as in SPIM
+	consoleRecvIRQ = 1 << 1
+	consoleSendIRQ = 1 << 0
+	// instructions the receiver waits for a character, and the
+	// transmitter is busy with one
+	consoleDelay = 100
+)
+
+// console is the console of SPIM. The receiver starts reading input once
+// the program reads its control register or enables its interrupt, so
+// that programs not using it do not wait for input. A character is read
+// consoleDelay instructions after the previous one has been taken.
+type console struct {
+	m                  *Machine
+	recvCtrl, recvData uint32
+	recvOn, eof        bool
+	recvWait           int
+	sendCtrl           uint32 // the interrupt enable bit
+	sendWait           int    // the transmitter is ready at zero
+}
+
+func (c *console) Read(offset uint32) uint32 {
+	switch offset {
+	case consoleRecvCtrl:
+		c.startRecv()
+		return c.recvCtrl
+	case consoleRecvData:
+		if c.recvCtrl&consoleReady != 0 {
+			c.recvCtrl &^= consoleReady
+			c.recvWait = consoleDelay
+		}
+		return c.recvData
+	case consoleSendCtrl:
+		if c.sendWait == 0 {
+			return c.sendCtrl | consoleReady
+		}
+		return c.sendCtrl
+	}
+	return 0
+}
+
+func (c *console) Write(offset uint32, value, mask uint32) {
+	ie := consoleIE & mask
+	switch offset {
+	case consoleRecvCtrl:
+		c.recvCtrl = c.recvCtrl&^ie | value&ie
+		if value&ie != 0 {
+			c.startRecv()
+		}
+	case consoleSendCtrl:
+		c.sendCtrl = c.sendCtrl&^ie | value&ie
+	case consoleSendData:
+		if mask&0xFF == 0 {
+			// the character is in the low byte
+			return
+		}
+		if c.sendWait > 0 {
+			// busy, the character is lost
+			return
+		}
+		_, err := c.m.stdout.Write([]byte{byte(value)})
+		checkInstErr(err)
+		c.sendWait = consoleDelay
+	}
+}
+
+func (c *console) startRecv() {
+	if !c.recvOn {
+		c.recvOn, c.recvWait = true, consoleDelay
+	}
+}
+
+func (c *console) Tick() uint8 {
+	if c.recvOn && c.recvCtrl&consoleReady == 0 && !c.eof {
+		if c.recvWait > 0 {
+			c.recvWait--
+		} else {
+			b, err := c.m.stdin.ReadByte()
+			if err == io.EOF {
+				c.eof = true
+			} else {
+				checkInstErr(err)
+				c.m.limit.read(1)
+				c.recvCtrl |= consoleReady
+				c.recvData = uint32(b)
+			}
+		}
+	}
+	if c.sendWait > 0 {
+		c.sendWait--
+	}
+	var irq uint8
+	if c.recvCtrl == consoleReady|consoleIE {
+		irq |= consoleRecvIRQ
+	}
+	if c.sendWait == 0 && c.sendCtrl&consoleIE != 0 {
+		irq |= consoleSendIRQ
+	}
+	return irq
+}
@@ -0,0 +1,236 @@
+package mips
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type testDevice struct {
+	regs         [2]uint32
+	ticks, reads int
+}
+
+func (d *testDevice) Read(offset uint32) uint32 { d.reads++; return d.regs[offset/4] }
+func (d *testDevice) Tick() uint8               { d.ticks++; return 0 }
+
+func (d *testDevice) Write(offset uint32, v, mask uint32) {
+	d.regs[offset/4] = d.regs[offset/4]&^mask | v&mask
+}
+
+func TestMapDevice(t *testing.T) {
+	d := &testDevice{regs: [2]uint32{0x11111111, 0xAABBCCDD}}
+	em := NewEmulator()
+	if err := em.MapDevice(0xFFFF1000, 8, d); err != nil {
+		t.Fatal(err)
+	}
+	// overlapping the device, unaligned, and in the stack and ktext
+	for _, addr := range []uint32{0xFFFF1004, 0xFFFF0FFC, 0xFFFF2002, 0x7EFFF000, 0x80001000} {
+		if err := em.MapDevice(addr, 8, d); err == nil {
+			t.Errorf("expect an error mapping a device at %#x", addr)
+		}
+	}
+	if err := em.LoadAndRun(assembleString(t, `.text
+main:
+	li $t0, 0xFFFF1000
+	li $t1, 0x12
+	sb $t1, 1($t0)
+	lw $s0, 4($t0)
+	lbu $s1, 5($t0)
+	li $v0, 10
+	syscall`)); err != nil {
+		t.Fatal(err)
+	}
+	for reg, want := range map[string]uint32{
+		"s0": 0xAABBCCDD,
+		"s1": 0xCC,
+	} {
+		if v, _ := em.ReadReg(reg); v != want {
+			t.Errorf("expect $%s = %#x, got %#x", reg, want, v)
+		}
+	}
+	if d.regs[0] != 0x11111211 {
+		t.Errorf("expect a byte stored in the register, got %#x", d.regs[0])
+	}
+	if d.ticks != 10 {
+		t.Errorf("expect the device to tick for 10 instructions, got %d", d.ticks)
+	}
+
+	// a layout moving the stack over the device
+	mapped := func(e *Emulator) {
+		if err := e.MapDevice(0x7F800000, 8, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	em = NewEmulator(mapped, WithLayout(MARSLayout))
+	a := NewAssembler(strings.NewReader(".text\nmain:\n\tnop"))
+	if err := a.SetLayout(MARSLayout); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := a.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := em.Load(raw); err == nil {
+		t.Error("expect an error loading with a device in the stack")
+	}
+
+	// the second console overlaps the first
+	em = NewEmulator(WithConsole(), WithConsole())
+	if err := em.Load(assembleString(t, ".text\nmain:\n\tnop")); err == nil {
+		t.Error("expect an error loading with overlapping consoles")
+	}
+}
+
+func TestConsolePolling(t *testing.T) {
+	out := new(bytes.Buffer)
+	em := NewEmulator(WithConsole(), WithStdin(strings.NewReader("x")), WithStdout(out))
+	if err := em.LoadAndRun(assembleString(t, `.text
+main:
+	lui $t0, 0xFFFF
+wait:
+	lw $t1, 0($t0)
+	andi $t1, $t1, 1
+	beqz $t1, wait
+	lw $a0, 4($t0)
+	jal putc
+	li $a0, 33
+	jal putc
+	li $v0, 10
+	syscall
+putc:
+	lw $t1, 8($t0)
+	andi $t1, $t1, 1
+	beqz $t1, putc
+	sw $a0, 12($t0)
+	jr $ra`)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "x!" {
+		t.Errorf("expect output x!, got %q", out.String())
+	}
+}
+
+func TestConsoleInterrupt(t *testing.T) {
+	em := NewEmulator(WithConsole(), WithStdin(strings.NewReader("abc")))
+	if err := em.LoadAndRun(assembleString(t, `.text
+main:
+	lui $t0, 0xFFFF
+	li $t1, 2
+	sw $t1, 0($t0)
+loop:
+	slti $t1, $s1, 3
+	bnez $t1, loop
+	li $v0, 10
+	syscall
+
+.ktext 0x80000180
+	mfc0 $k0, $13
+	andi $k0, $k0, 0x800
+	beqz $k0, done
+	lui $k0, 0xFFFF
+	lw $k1, 4($k0)
+	sll $s2, $s2, 8
+	or $s2, $s2, $k1
+	addi $s1, $s1, 1
+done:
+	eret`)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadReg("s2"); v != 0x616263 {
+		t.Errorf("expect to receive abc, got %#x", v)
+	}
+	if v, _ := em.ReadReg("Cause"); ExcCode(v>>2&0x1F) != EXC_INT {
+		t.Errorf("expect Cause = %s, got %#x", EXC_INT, v)
+	}
+}
+
+// interruptHandler counts the receiver interrupts of the console in $s1,
+// receiving the bytes into $s2
+const interruptHandler = `
+.ktext 0x80000180
+	mfc0 $k0, $13
+	andi $k0, $k0, 0x800
+	beqz $k0, done
+	lui $k0, 0xFFFF
+	lw $k1, 4($k0)
+	sll $s2, $s2, 8
+	or $s2, $s2, $k1
+	addi $s1, $s1, 1
+done:
+	eret`
+
+// pulseDevice requests hardware interrupt 0 for one instruction out of
+// every 50, five times
+type pulseDevice struct {
+	ticks, pulses int
+}
+
+func (d *pulseDevice) Read(offset uint32) uint32    { return 0 }
+func (d *pulseDevice) Write(offset, v, mask uint32) {}
+
+func (d *pulseDevice) Tick() uint8 {
+	if d.ticks++; d.ticks%50 != 0 || d.pulses == 5 {
+		return 0
+	}
+	d.pulses++
+	return 1
+}
+
+func TestInterruptCores(t *testing.T) {
+	// Core 0 waits for interrupts while core 1 spins
+	src := `.text
+main:
+	rdhwr $t0, $0
+	bnez $t0, spin
+	lui $t0, 0xFFFF
+	li $t1, 2
+	sw $t1, 0($t0)
+loop:
+	slti $t1, $s1, 3
+	bnez $t1, loop
+	li $v0, 10
+	syscall
+spin:
+	j spin
+` + interruptHandler
+	em := NewEmulator(WithCores(2), WithScheduler(7, 1),
+		WithConsole(), WithStdin(strings.NewReader("abc")))
+	if err := em.LoadAndRun(assembleString(t, src)); err != nil {
+		t.Fatal(err)
+	}
+	em.SelectCore(0)
+	if v, _ := em.ReadReg("s2"); v != 0x616263 {
+		t.Errorf("expect core 0 to receive abc, got %#x", v)
+	}
+
+	// a request made while core 1 runs waits for core 0, which stops
+	// after it takes all of them
+	d := &pulseDevice{}
+	em = NewEmulator(WithCores(2), WithScheduler(7, 1),
+		WithLimits(Limits{Instructions: 10000}))
+	if err := em.MapDevice(0xFFFF1000, 4, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.LoadAndRun(assembleString(t, `.text
+main:
+	rdhwr $t0, $0
+	bnez $t0, spin
+loop:
+	slti $t1, $s1, 5
+	bnez $t1, loop
+	li $v0, 10
+	syscall
+spin:
+	j spin
+
+.ktext 0x80000180
+	addi $s1, $s1, 1
+	eret`)); err != nil {
+		t.Fatal(err)
+	}
+	em.SelectCore(0)
+	if v, _ := em.ReadReg("s1"); v != 5 {
+		t.Errorf("expect 5 interrupts, got %d", v)
+	}
+}
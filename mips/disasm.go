@@ -4,31 +4,43 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
-	"regexp"
-	"strconv"
 	"strings"
 )
 
-var (
-	headerPattern = regexp.MustCompile(`^text:([0-9]+),data:([0-9]+),main:([0-9]+)$`)
-)
-
 type Disassembler struct {
-	r          *bufio.Reader
-	textOffset int
-	dataOffset int
-	mainOffset int
-	eof        bool
+	r           *bufio.Reader
+	textOffset  int
+	dataOffset  int
+	mainOffset  int
+	kernel      bool
+	ktextOffset int
+	kdataOffset int
+	order       binary.ByteOrder
+	pos         int // number of bytes read after the header
+	eof         bool
 }
 
-// Disassemble disassemble each 4 bytes into an instruction
+// Disassemble disassemble each 4 bytes into an instruction, in
+// little-endian order as Assemble produces
 func Disassemble(raw []byte) ([]byte, error) {
-	var result []byte
+	var words []uint32
 	for i := 0; i+4 <= len(raw); i += 4 {
-		s, err := disasm(raw[i : i+4])
+		words = append(words, binary.LittleEndian.Uint32(raw[i:i+4]))
+	}
+	return disasmWords(words)
+}
+
+// DisassembleWord disassembles an instruction word
+func DisassembleWord(word uint32) ([]byte, error) {
+	return disasm(word)
+}
+
+func disasmWords(words []uint32) ([]byte, error) {
+	var result []byte
+	for _, w := range words {
+		s, err := disasm(w)
 		if err != nil {
 			return nil, err
 		}
@@ -60,34 +72,40 @@ func (d *Disassembler) parseHeader() error {
 	if err != nil {
 		return err
 	}
-	line = line[:len(line)-1]
-	if !headerPattern.MatchString(line) {
-		return errors.New("invalid header")
+	h, err := parseHeader(line[:len(line)-1])
+	if err != nil {
+		return err
 	}
+	d.textOffset = h.text
+	d.dataOffset = h.data
+	d.mainOffset = h.main
+	d.kernel = h.hasKernel()
+	d.ktextOffset = h.ktext
+	d.kdataOffset = h.kdata
+	d.order = h.order
+	return nil
+}
 
-	sub := headerPattern.FindStringSubmatch(line)
-
-	text, err := strconv.ParseInt(sub[1], 10, 32)
+func (d *Disassembler) disassemble() ([]byte, error) {
+	ret, err := d.disasmSegment(d.textOffset, d.dataOffset)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	d.textOffset = int(text)
-	data, err := strconv.ParseInt(sub[2], 10, 32)
-	if err != nil {
-		panic(err.Error())
+	if !d.kernel || d.eof {
+		return ret, nil
 	}
-	d.dataOffset = int(data)
-	main, err := strconv.ParseInt(sub[3], 10, 32)
+	ktext, err := d.disasmSegment(d.ktextOffset, d.kdataOffset)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	d.mainOffset = int(main)
-	return nil
+	ret = append(ret, "\n.ktext\n"...)
+	return append(ret, ktext...), nil
 }
 
-func (d *Disassembler) disassemble() ([]byte, error) {
-	var i int
-	for ; i < d.textOffset; i++ {
+// disasmSegment disassembles bytes between offset start and end, or
+// up to the end of input if end is not after start.
+func (d *Disassembler) disasmSegment(start, end int) ([]byte, error) {
+	for ; d.pos < start; d.pos++ {
 		_, err := d.r.ReadByte()
 		if err != nil {
 			return nil, err
@@ -95,13 +113,13 @@ func (d *Disassembler) disassemble() ([]byte, error) {
 	}
 
 	cmp := false
-	if d.dataOffset > d.textOffset {
+	if end > start {
 		cmp = true
 	}
 
 	ret := []byte{}
-	for ; ; i += 4 {
-		if cmp && i >= d.dataOffset {
+	for ; ; d.pos += 4 {
+		if cmp && d.pos >= end {
 			break
 		}
 
@@ -118,7 +136,7 @@ func (d *Disassembler) disassemble() ([]byte, error) {
 			s = append(s, b)
 		}
 
-		line, err := disasm(s)
+		line, err := disasm(d.order.Uint32(s))
 		if err != nil {
 			return nil, err
 		}
@@ -130,24 +148,15 @@ RETURN:
 	return ret, nil
 }
 
-func disasm(s []byte) ([]byte, error) {
-	raw := binary.LittleEndian.Uint32(s)
+func disasm(raw uint32) ([]byte, error) {
+	if raw == 0 {
+		// sll $zero, $zero, 0
+		return []byte("nop"), nil
+	}
 	// obtain instruction name
-	opcode := (raw >> 26) & 0x3F
-	var name string
-	var ok bool
-	switch opcode {
-	case 0:
-		funct := raw & 0x3F
-		name, ok = rInstructions[int(funct)]
-		if !ok {
-			return nil, errors.New("unsupported function code")
-		}
-	default:
-		name, ok = ijInstructions[int(opcode)]
-		if !ok {
-			return nil, errors.New("unsupported opcode")
-		}
+	name, err := lookup(raw)
+	if err != nil {
+		return nil, err
 	}
 
 	// disassemble
@@ -167,6 +176,23 @@ func disasm(s []byte) ([]byte, error) {
 			}
 			token = "$" + token
 			j++
+		case inst.syntax[i]&argFReg != 0:
+			var n uint32
+			switch inst.formats[j] {
+			case fmtRegS:
+				n = (raw >> 21) & 0x1F
+			case fmtRegT:
+				n = (raw >> 16) & 0x1F
+			case fmtRegD:
+				n = (raw >> 11) & 0x1F
+			case fmtFd:
+				n = (raw >> 6) & 0x1F
+			}
+			token = fmt.Sprintf("$f%d", n)
+			j++
+		case inst.syntax[i]&argCReg != 0:
+			token = fmt.Sprintf("$%d", (raw>>11)&0x1F)
+			j++
 		case inst.syntax[i]&argInteger != 0:
 			switch inst.formats[j] {
 			case fmtShamt:
@@ -175,6 +201,12 @@ func disasm(s []byte) ([]byte, error) {
 				token = fmt.Sprintf("%d", int16(raw&0xFFFF))
 			case fmtAddress:
 				token = fmt.Sprintf("%#x", raw&0x3FFFFFF)
+			case fmtExtSize:
+				token = fmt.Sprintf("%d", (raw>>11)&0x1F+1)
+			case fmtInsSize:
+				token = fmt.Sprintf("%d", int((raw>>11)&0x1F)-int((raw>>6)&0x1F)+1)
+			case fmtCode:
+				token = fmt.Sprintf("%d", (raw>>6)&0x3FF)
 			}
 			j++
 		case inst.syntax[i]&argAddr != 0:
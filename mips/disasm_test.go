@@ -44,3 +44,95 @@ func TestDisasmFile(t *testing.T) {
 		}
 	}
 }
+
+func TestDisasmRoundTrip(t *testing.T) {
+	for _, src := range []string{
+		"nop",
+		"sltu $t0, $t1, $t2",
+		"sltiu $t0, $t1, -1",
+		"xori $t0, $t1, 255",
+		"movz $t0, $t1, $t2",
+		"movn $t0, $t1, $t2",
+		"mthi $a0",
+		"mtlo $a1",
+		"jalr $s0, $t9",
+		"blez $a0, -3",
+		"bgtz $a0, 4",
+		"bltz $s1, -1",
+		"bgez $s1, 0",
+		"bltzal $t0, 8",
+		"bgezal $zero, -8",
+		"break",
+		"lwl $t0, 3($a0)",
+		"lwr $t0, 0($a0)",
+		"swl $t0, -1($a0)",
+		"swr $t0, 4($a0)",
+		"ll $t1, 0($t0)",
+		"sc $t1, 0($t0)",
+		"srl $t0, $t1, 3",
+		"rotr $t0, $t1, 3",
+		"srlv $t0, $t1, $t2",
+		"rotrv $t0, $t1, $t2",
+		"mul $t0, $t1, $t2",
+		"madd $t1, $t2",
+		"maddu $t1, $t2",
+		"msub $t1, $t2",
+		"msubu $t1, $t2",
+		"clz $t0, $t1",
+		"clo $t0, $t1",
+		"ext $t0, $t1, 5, 27",
+		"ins $t0, $t1, 31, 1",
+		"wsbh $t0, $t1",
+		"seb $t0, $t1",
+		"seh $t0, $t1",
+		"add.s $f0, $f2, $f4",
+		"div.d $f30, $f2, $f4",
+		"sqrt.s $f1, $f31",
+		"cvt.w.d $f0, $f2",
+		"cvt.d.w $f0, $f2",
+		"c.lt.d $f2, $f4",
+		"c.ngt.s $f2, $f4",
+		"mfc1 $t0, $f3",
+		"mtc1 $t0, $f3",
+		"cfc1 $t0, $31",
+		"ctc1 $t0, $31",
+		"lwc1 $f1, 4($sp)",
+		"sdc1 $f2, -8($sp)",
+		"bc1t 3",
+		"bc1f -3",
+		"teq $t0, $t1, 0",
+		"tne $t0, $zero, 1023",
+		"tge $t0, $t1, 5",
+		"tgeu $t0, $t1, 5",
+		"tlt $t0, $t1, 5",
+		"tltu $t0, $t1, 5",
+		"teqi $t0, -1",
+		"tnei $t0, 7",
+		"tgei $t0, 7",
+		"tgeiu $t0, 7",
+		"tlti $t0, 7",
+		"tltiu $t0, 7",
+	} {
+		code, err := Assemble([]byte(src))
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		d, err := Disassemble(code)
+		if err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		again, err := Assemble(d)
+		if err != nil {
+			t.Errorf("%s: reassemble %q: %v", src, d, err)
+			continue
+		}
+		if !bytes.Equal(code, again) {
+			t.Errorf("%s: disassembled to %q, which assembles to % x", src, d, again)
+		}
+	}
+	if d, _ := Disassemble([]byte{0, 0, 0, 0}); string(d) != "nop" {
+		t.Errorf("expect word 0 to disassemble to nop, got %q", d)
+	}
+}
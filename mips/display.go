@@ -0,0 +1,127 @@
+package mips
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// DefaultFrameLength is the number of instructions of a frame of a
+// display, unless set otherwise
+const DefaultFrameLength = 100000
+
+// Display is the bitmap display of MARS. It shows the memory it is
+// attached to with Emulator.AttachDisplay, usually a framebuffer the
+// program declares with .space at the start of .data. Each word is the
+// color 0x00RRGGBB of a unit of Unit by Unit pixels, row by row.
+type Display struct {
+	Width, Height int // in pixels
+	Unit          int // pixels of a side of a unit
+	// FrameLength is the number of instructions of a frame
+	FrameLength int
+
+	mem          *virtualMemory // nil until attached
+	base         uint32
+	ticks, frame int
+	every        int
+	onFrame      func(frame int)
+}
+
+// NewDisplay returns a black display of width by height pixels, which
+// must be multiples of unit
+func NewDisplay(width, height, unit int) (*Display, error) {
+	if unit <= 0 || width <= 0 || height <= 0 || width%unit != 0 || height%unit != 0 {
+		return nil, errors.New("display: size must be a positive multiple of the unit")
+	}
+	return &Display{
+		Width:       width,
+		Height:      height,
+		Unit:        unit,
+		FrameLength: DefaultFrameLength,
+	}, nil
+}
+
+// Size returns the number of bytes of memory the display shows
+func (d *Display) Size() uint32 {
+	return uint32(d.Width / d.Unit * d.Height / d.Unit * 4)
+}
+
+// AttachDisplay makes d show the memory at addr, a multiple of 4. The
+// program draws with ordinary stores; the display only reads memory.
+func (e *Emulator) AttachDisplay(addr uint32, d *Display) error {
+	if addr&3 != 0 || uint64(addr)+uint64(d.Size()) > 1<<32 {
+		return fmt.Errorf("attach display %#x: bad address range", addr)
+	}
+	d.mem, d.base = e.machine.m, addr
+	e.machine.m.displays = append(e.machine.m.displays, d)
+	return nil
+}
+
+// EveryFrames makes the display call fn at the end of every n frames,
+// with the number of frames shown so far
+func (d *Display) EveryFrames(n int, fn func(frame int)) {
+	d.every, d.onFrame = n, fn
+}
+
+// tick advances the display by one instruction
+func (d *Display) tick() {
+	if d.ticks++; d.ticks >= d.FrameLength {
+		d.ticks = 0
+		d.frame++
+		if d.onFrame != nil && d.every > 0 && d.frame%d.every == 0 {
+			d.onFrame(d.frame)
+		}
+	}
+}
+
+// unit returns the color of a unit. Memory that is not allocated is
+// black, and devices are not read.
+func (d *Display) unit(x, y int) color.RGBA {
+	var c uint32
+	if d.mem != nil {
+		c = d.mem.peekWord(d.base + uint32(4*(y*(d.Width/d.Unit)+x)))
+	}
+	return color.RGBA{R: uint8(c >> 16), G: uint8(c >> 8), B: uint8(c), A: 0xFF}
+}
+
+// Image returns a snapshot of the display
+func (d *Display) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, d.Width, d.Height))
+	u := d.Unit
+	for y := 0; y < d.Height/u; y++ {
+		for x := 0; x < d.Width/u; x++ {
+			r := image.Rect(x*u, y*u, (x+1)*u, (y+1)*u)
+			draw.Draw(img, r, &image.Uniform{d.unit(x, y)}, image.Point{}, draw.Src)
+		}
+	}
+	return img
+}
+
+// WritePNG writes a snapshot of the display to w in PNG format
+func (d *Display) WritePNG(w io.Writer) error {
+	return png.Encode(w, d.Image())
+}
+
+// WriteANSI draws the display to a terminal with 24-bit colors. Each
+// character shows two units, one above the other.
+func (d *Display) WriteANSI(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	cols, rows := d.Width/d.Unit, d.Height/d.Unit
+	for y := 0; y < rows; y += 2 {
+		for x := 0; x < cols; x++ {
+			top, bottom := d.unit(x, y), color.RGBA{}
+			if y+1 < rows {
+				bottom = d.unit(x, y+1)
+			}
+			fmt.Fprintf(bw, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀",
+				top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+		}
+		bw.WriteString("\x1b[0m\n")
+	}
+	return bw.Flush()
+}
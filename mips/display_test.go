@@ -0,0 +1,104 @@
+package mips
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestDisplay(t *testing.T) {
+	d, err := NewDisplay(8, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.FrameLength = 4
+	var frames []int
+	d.EveryFrames(2, func(frame int) { frames = append(frames, frame) })
+	em := NewEmulator(WithLayout(MARSLayout))
+	if err := em.AttachDisplay(0x10010000, d); err != nil {
+		t.Fatal(err)
+	}
+	a := NewAssembler(strings.NewReader(`.data
+fb:	.space 32
+n:	.word 42
+.text
+main:
+	la $t0, fb
+	li $t1, 0x00FF0000
+	sw $t1, 0($t0)
+	li $t1, 0xFF
+	sb $t1, 28($t0)
+	la $t2, n
+	lw $s0, 0($t2)
+	li $v0, 10
+	syscall`))
+	if err := a.SetLayout(MARSLayout); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := a.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := em.LoadAndRun(raw); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadReg("s0"); v != 42 {
+		t.Errorf("expect the data after the framebuffer kept, got %d", v)
+	}
+	if len(frames) != 1 || frames[0] != 2 {
+		t.Errorf("expect a call at frame 2, got %v", frames)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := d.WritePNG(buf); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []struct {
+		x, y int
+		c    color.RGBA
+	}{
+		{0, 0, color.RGBA{0xFF, 0, 0, 0xFF}},
+		{1, 1, color.RGBA{0xFF, 0, 0, 0xFF}},
+		{2, 0, color.RGBA{0, 0, 0, 0xFF}},
+		{7, 3, color.RGBA{0, 0, 0xFF, 0xFF}},
+	} {
+		if c := color.RGBAModel.Convert(img.At(p.x, p.y)); c != p.c {
+			t.Errorf("expect %v at (%d, %d), got %v", p.c, p.x, p.y, c)
+		}
+	}
+
+	buf.Reset()
+	if err := d.WriteANSI(buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "▀"); n != 4 {
+		t.Errorf("expect 4 characters for 4x2 units, got %d", n)
+	}
+	if !strings.HasPrefix(buf.String(), "\x1b[38;2;255;0;0m\x1b[48;2;0;0;0m") {
+		t.Errorf("unexpected first character %q", buf.String())
+	}
+
+	// showing a device does not read it
+	dev := &testDevice{regs: [2]uint32{0xFF, 0xFF}}
+	em = NewEmulator()
+	if err := em.MapDevice(0xFFFF1000, 8, dev); err != nil {
+		t.Fatal(err)
+	}
+	d, _ = NewDisplay(2, 1, 1)
+	if err := em.AttachDisplay(0xFFFF1000, d); err != nil {
+		t.Fatal(err)
+	}
+	if c := d.Image().RGBAAt(0, 0); dev.reads != 0 || c != (color.RGBA{0, 0, 0, 0xFF}) {
+		t.Errorf("expect a black unit without reads, got %v after %d reads", c, dev.reads)
+	}
+
+	if _, err := NewDisplay(10, 4, 4); err == nil {
+		t.Error("expect an error for a size not a multiple of the unit")
+	}
+}
@@ -1,11 +1,15 @@
 package mips
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"strconv"
+	"io"
+	"math/rand"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,87 +23,220 @@ const (
 	EXIT_EOF
 	EXIT_TIMEOUT
 	EXIT_ERROR
+	EXIT_LIMIT
 )
 
 // Emulator runs machine code virtually
 type Emulator struct {
+	// machine is the current core, which runs next
 	machine *Machine
-	running bool
-	timer   *time.Timer
-	inst    chan execInst
-	next    chan int
-	exit    chan ExitStatus
-	err     chan error
+	cores   []*Machine
+	// the scheduler switches to a random core every quantum instructions
+	sched         *rand.Rand
+	quantum, left int
+	running       bool
+	timer         *time.Timer
+	// hardware interrupts requested by devices while cores other than
+	// core 0 ran, in the position of Cause
+	irq uint32
+	// stop holds an exit status requested asynchronously by Exit or the
+	// timer, zero if none. EXIT_NORMAL is never requested this way.
+	// Requests also wake a sleeping program.
+	stop int32
+	wake chan struct{}
 }
 
 type execInst struct {
-	name   string
-	f      instFunc
-	args   []int // function arguments
-	branch bool  // Is branch instruction?
-	eof    bool
+	name string
+	f    instFunc
+	args []int // function arguments
+	eof  bool
 }
 
-func NewEmulator() *Emulator {
-	return &Emulator{
+// eofInst is fetched at the end of text, and stops the program
+var eofInst = &execInst{eof: true}
+
+// defaultQuantum is the number of instructions a core runs before the
+// scheduler switches cores, unless set otherwise
+const defaultQuantum = 100
+
+// coreStack is the stack space of each core. The stack of core i starts
+// i*coreStack below the initial $sp.
+const coreStack = 0x100000
+
+// pollInterval is the number of instructions Run executes between
+// checks of its context.
+const pollInterval = 1 << 10
+
+// Option configures an Emulator created by NewEmulator
+type Option func(*Emulator)
+
+// WithBranchDelay enables the branch delay slot: the instruction following
+// a branch or jump is executed before control is transferred.
+func WithBranchDelay() Option {
+	return func(e *Emulator) { e.machine.branchDelay = true }
+}
+
+// WithLoadDelay enables the MIPS I load delay: the result of a load is not
+// visible to the instruction following it.
+func WithLoadDelay() Option {
+	return func(e *Emulator) { e.machine.loadDelay = true }
+}
+
+// WithStdin makes the program read its standard input from r
+func WithStdin(r io.Reader) Option {
+	return func(e *Emulator) { e.machine.stdin = bufio.NewReader(r) }
+}
+
+// WithStdout makes the program write its standard output to w
+func WithStdout(w io.Writer) Option {
+	return func(e *Emulator) { e.machine.stdout = w }
+}
+
+// WithStderr makes the program write its standard error to w
+func WithStderr(w io.Writer) Option {
+	return func(e *Emulator) { e.machine.stderr = w }
+}
+
+// WithFileSystem makes the file syscalls use fs. By default, they use an
+// empty file system in memory, and never reach the host.
+func WithFileSystem(fs FileSystem) Option {
+	return func(e *Emulator) { e.machine.fs = fs }
+}
+
+// WithWritableText allows stores to the text segments, for programs
+// modifying their own code.
+func WithWritableText() Option {
+	return func(e *Emulator) {
+		e.machine.m.perms[textSegment] |= PermWrite
+		e.machine.m.perms[ktextSegment] |= PermWrite
+	}
+}
+
+// WithLayout places the segments of the program as l tells. Programs
+// must be assembled for the same layout.
+func WithLayout(l MemoryLayout) Option {
+	return func(e *Emulator) { e.machine.m.setLayout(l) }
+}
+
+// WithByteOrder sets the byte order of programs started by StartOnline.
+// Load uses the byte order recorded in the object.
+func WithByteOrder(order binary.ByteOrder) Option {
+	return func(e *Emulator) { e.machine.m.order = order }
+}
+
+// WithCores makes the machine run n cores over the same memory. All
+// of them start at main, each with its own stack.
+func WithCores(n int) Option {
+	return func(e *Emulator) {
+		if n > 1 {
+			e.cores = make([]*Machine, n)
+		}
+	}
+}
+
+// WithScheduler makes the scheduler switch cores every quantum
+// instructions, choosing them at random from seed, so that runs with the
+// same seed interleave the same way. By default, the seed is 0 and the
+// quantum is 100.
+func WithScheduler(seed int64, quantum int) Option {
+	return func(e *Emulator) {
+		e.sched = rand.New(rand.NewSource(seed))
+		if quantum > 0 {
+			e.quantum = quantum
+		}
+	}
+}
+
+// WithHeapLimit limits the heap to n bytes. By default it may grow to
+// the end of the data segment.
+func WithHeapLimit(n uint32) Option {
+	return func(e *Emulator) { e.machine.m.heapLimit = n }
+}
+
+func NewEmulator(opts ...Option) *Emulator {
+	e := &Emulator{
 		machine: NewMachine(),
-		inst:    make(chan execInst),
-		next:    make(chan int, 2),
-		exit:    make(chan ExitStatus),
-		err:     make(chan error, 2),
+		quantum: defaultQuantum,
+		wake:    make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.cores == nil {
+		e.cores = make([]*Machine, 1)
 	}
+	if m := e.machine; m.limit.Output != 0 {
+		m.stdout = &limitWriter{m.stdout, m.limit}
+		m.stderr = &limitWriter{m.stderr, m.limit}
+	}
+	e.cores[0] = e.machine
+	for i := 1; i < len(e.cores); i++ {
+		e.cores[i] = e.machine.newCore(i)
+	}
+	for _, c := range e.cores {
+		c.cores = e.cores
+	}
+	if e.sched == nil {
+		e.sched = rand.New(rand.NewSource(0))
+	}
+	e.left = e.quantum
+	return e
 }
 
+// SetTimer stops the program with EXIT_TIMEOUT after duration d.
 func (e *Emulator) SetTimer(d time.Duration) {
+	if e.timer != nil {
+		e.timer.Stop()
+	}
 	e.timer = time.AfterFunc(d, func() {
-		e.exit <- EXIT_TIMEOUT
+		e.requestStop(EXIT_TIMEOUT)
 	})
 }
 
+// LoadAndRun loads object codes and runs them to the end
 func (e *Emulator) LoadAndRun(raw []byte) error {
 	err := e.Load(raw)
 	if err != nil {
 		return err
 	}
-	e.Run()
-	return nil
+	_, err = e.Run(context.Background())
+	return err
 }
 
-func (e *Emulator) Run() {
-	go func() {
-		for {
-			e.step()
+// Run executes instructions until the program exits, fails, or is
+// stopped by ctx, Exit, the timer or a limit. A non-nil error is
+// returned unless the program terminated normally or was stopped by Exit.
+func (e *Emulator) Run(ctx context.Context) (ExitStatus, error) {
+	e.running = true
+	done := ctx.Done()
+	for {
+		for i := 0; i < pollInterval; i++ {
+			status, stopped, err := e.step()
+			if stopped {
+				e.running = false
+				return status, err
+			}
+			slept := e.sleep(done)
+			e.schedule()
+			if slept {
+				// check for stops that ended the sleep early
+				break
+			}
 		}
-	}()
-	go func() {
-		for {
-			<-e.next
-			e.fetch()
+		if status, ok := e.stopRequested(); ok {
+			e.running = false
+			return status, stopError(status)
 		}
-	}()
-	e.next <- 1
-	e.running = true
-}
-
-func (e *Emulator) Wait() error {
-	if !e.running {
-		return errors.New("Program is not running")
-	}
-	select {
-	case status := <-e.exit:
-		e.running = false
-		switch status {
-		case EXIT_ERROR:
-			return <-e.err
-		case EXIT_NORMAL, EXIT_EOF:
-			return nil
-		case EXIT_TIMEOUT:
-			return errors.New("timeout")
+		select {
+		case <-done:
+			e.running = false
+			if ctx.Err() == context.DeadlineExceeded {
+				return EXIT_TIMEOUT, ctx.Err()
+			}
+			return EXIT_INT, ctx.Err()
 		default:
-			return nil
 		}
-	case err := <-e.err:
-		return err
 	}
 }
 
@@ -112,124 +249,236 @@ func (e *Emulator) LoadAndStart(raw []byte) error {
 	return nil
 }
 
+// Start prepares the loaded program for stepping
 func (e *Emulator) Start() {
 	e.running = true
-	e.next <- 1
 }
 
+// Step executes exactly one instruction
 func (e *Emulator) Step() error {
 	if !e.running {
 		return errors.New("Program is not running")
 	}
-	go e.step()
-	select {
-	case status := <-e.exit:
-		e.running = false
-		switch status {
-		case EXIT_NORMAL, EXIT_EOF, EXIT_INT:
-			return errors.New("Program exited, exit status: " +
-				status.String())
-		case EXIT_ERROR:
-			return <-e.err
-		default:
-			panic("something wrong...")
-		}
-	case <-e.next:
-		e.fetch()
+	status, stopped, err := e.step()
+	if !stopped {
+		e.sleep(nil)
+		status, stopped = e.stopRequested()
+		err = stopError(status)
+	}
+	if !stopped {
+		e.schedule()
 		return nil
 	}
+	e.running = false
+	if err != nil {
+		return err
+	}
+	return errors.New("Program exited, exit status: " + status.String())
+}
+
+// ExitCode returns the code passed to the exit2 system call, or zero
+func (e *Emulator) ExitCode() int {
+	return e.machine.exitCode
 }
 
+// Exit interrupts the running program. It is safe to call from
+// another goroutine.
 func (e *Emulator) Exit() {
-	e.exit <- EXIT_INT
+	e.requestStop(EXIT_INT)
 }
 
-func (e *Emulator) step() {
-	defer func() {
-		if err := recover(); err != nil {
-			e.err <- fmt.Errorf("%v", err)
-			e.exit <- EXIT_ERROR
-		}
-	}()
-	inst := <-e.inst
-	if inst.eof {
-		e.exit <- EXIT_EOF
+func (e *Emulator) requestStop(status ExitStatus) {
+	atomic.StoreInt32(&e.stop, int32(status))
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sleep takes the sleep requested by the current core, if any. It ends
+// early when done is closed or a stop is requested, so that the sleep
+// lasts at most the time left to the program. It reports whether the
+// core slept.
+func (e *Emulator) sleep(done <-chan struct{}) bool {
+	d := e.machine.sleep
+	e.machine.sleep = 0
+	if d <= 0 {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-done:
+	case <-e.wake:
+	}
+	return true
+}
+
+func (e *Emulator) stopRequested() (ExitStatus, bool) {
+	select {
+	case <-e.wake:
+	default:
+	}
+	status := atomic.SwapInt32(&e.stop, 0)
+	return ExitStatus(status), status != 0
+}
+
+func stopError(status ExitStatus) error {
+	if status == EXIT_TIMEOUT {
+		return errors.New("timeout")
+	}
+	return nil
+}
+
+// schedule switches to a core chosen at random at the end of a quantum
+func (e *Emulator) schedule() {
+	if len(e.cores) == 1 {
 		return
 	}
-	inst.f(e.machine, inst.args...)
-	if e.machine.exit {
-		e.exit <- EXIT_NORMAL
+	if e.left--; e.left > 0 {
+		return
+	}
+	e.left = e.quantum
+	e.machine = e.cores[e.sched.Intn(len(e.cores))]
+}
+
+// tick advances the devices by an instruction of any core. They
+// interrupt core 0, whose Cause holds the requests made since its last
+// instruction, so that those made while other cores run are not lost.
+func (e *Emulator) tick() {
+	m := e.machine.m
+	if len(m.devices) == 0 && len(m.displays) == 0 {
 		return
 	}
-	if !inst.branch {
-		e.machine.r.PC = e.machine.r.PC + 4
+	irq := uint32(m.tick()) << 10 & causeIPHard
+	if e.machine.id != 0 {
+		e.irq |= irq
+		return
 	}
-	e.next <- 1
+	c0 := &e.machine.c0
+	c0[c0Cause] = c0[c0Cause]&^(causeIPHard&^(timerIRQ<<10)) | e.irq | irq
+	e.irq = 0
 }
 
-func (e *Emulator) fetch() {
+// step fetches and executes one instruction. It reports whether the
+// program stopped, and if so, its exit status.
+func (e *Emulator) step() (status ExitStatus, stopped bool, err error) {
 	defer func() {
-		if err := recover(); err != nil {
-			e.exit <- EXIT_ERROR
-			e.err <- fmt.Errorf("%v", err)
+		if r := recover(); r != nil {
+			if exc, ok := r.(*Exception); ok {
+				status, stopped, err = e.exception(exc)
+				return
+			}
+			if le, ok := r.(*LimitError); ok {
+				status, stopped, err = EXIT_LIMIT, true, le
+				return
+			}
+			status, stopped, err = EXIT_ERROR, true, fmt.Errorf("%v", r)
 		}
 	}()
-	s, err := e.fetchRaw(1)
+	e.machine.limit.instruction()
+	e.tick()
+	e.machine.interrupt()
+	inst := e.fetch()
+	if inst.eof {
+		// fell off the end of text
+		return EXIT_EOF, true, nil
+	}
+	inst.f(e.machine, inst.args...)
+	if e.machine.exit {
+		return EXIT_NORMAL, true, nil
+	}
+	e.machine.advance()
+	return EXIT_NORMAL, false, nil
+}
+
+// exception handles an exception raised by the current instruction.
+// It jumps to the exception handler if one is loaded; otherwise, or if
+// the handler itself faults, the program stops with exc.
+func (e *Emulator) exception(exc *Exception) (ExitStatus, bool, error) {
+	m := e.machine
+	if m.c0[c0Status]&statusEXL != 0 {
+		return EXIT_ERROR, true, exc
+	}
+	m.enterException(exc)
+	if !m.handler {
+		return EXIT_ERROR, true, exc
+	}
+	m.r.PC = EXC_VECTOR
+	return EXIT_NORMAL, false, nil
+}
+
+// fetch returns the instruction at PC, decoding it only if it is not
+// in the decode cache yet.
+func (e *Emulator) fetch() *execInst {
+	m := e.machine
+	pc := m.r.PC
+	// checked before the cache, which also holds kernel code
+	if pc&3 != 0 || !m.accessible(pc) {
+		m.raise(EXC_ADEL, pc)
+	}
+	if inst := m.m.cachedInst(pc); inst != nil {
+		return inst
+	}
+	if pc == m.m.textEnd {
+		return eofInst
+	}
+	if !m.m.executable(pc) {
+		m.raise(EXC_IBE, pc)
+	}
+	raw, err := m.m.readWord(pc)
 	if err != nil {
-		panic(err)
+		m.raise(EXC_IBE, pc)
 	}
-	inst, err := resolve(s)
+	inst, err := resolve(raw)
 	if err != nil {
-		panic(err)
+		m.raise(EXC_RI, 0)
 	}
-	e.inst <- *inst
+	m.m.cacheInst(pc, inst)
+	return inst
 }
 
-func (e *Emulator) fetchRaw(n int) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	for i := 0; i < n; i++ {
-		raw, err := e.machine.m.readWord(e.machine.r.PC + i<<2)
-		if err != nil {
-			return nil, err
-		}
-		err = binary.Write(buf, binary.LittleEndian, uint32(raw))
+// fetchRaw reads n words of machine code from PC
+func (e *Emulator) fetchRaw(n int) ([]uint32, error) {
+	words := make([]uint32, n)
+	for i := range words {
+		var err error
+		words[i], err = e.machine.m.readWord(e.machine.r.PC + uint32(i<<2))
 		if err != nil {
 			return nil, err
 		}
 	}
-	return buf.Bytes(), nil
+	return words, nil
 }
 
 // StartOnline loads emulator with empty code
 func (e *Emulator) StartOnline() error {
-	err := e.Load([]byte("text:0,data:0,main:0\n"))
+	l := e.machine.m.layout
+	h := &objectHeader{textAddr: l.Text, dataAddr: l.Data, order: e.machine.m.order}
+	err := e.Load([]byte(h.String() + "\n"))
 	if err != nil {
 		return err
 	}
-	go func() {
-		for {
-			e.step()
-		}
-	}()
-	e.next <- 1
+	e.Start()
 	return nil
 }
 
-// FetchOnline fetches 4 bytes as an instruction
-func (e *Emulator) FetchOnline(raw []byte) {
+// FetchOnline stores an instruction assembled by Assemble at PC and
+// executes it
+func (e *Emulator) FetchOnline(raw []byte) error {
 	if len(raw) != 4 {
-		e.exit <- EXIT_ERROR
-		e.err <- errors.New("bad machine code")
-		return
+		return errors.New("bad machine code")
 	}
-	addr := e.machine.r.PC
-	err := e.machine.m.writeBytes(addr, raw)
+	m, pc := e.machine.m, e.machine.r.PC
+	err := m.writeWord(pc, binary.LittleEndian.Uint32(raw))
 	if err != nil {
-		e.exit <- EXIT_ERROR
-		e.err <- fmt.Errorf("%v", err)
-		return
+		return err
+	}
+	if pc >= m.textEnd && m.segment(pc) == textSegment {
+		m.textEnd = pc + 4
 	}
-	e.fetch()
+	return e.Step()
 }
 
 // Load loads object codes into emulator
@@ -238,78 +487,87 @@ func (e *Emulator) Load(code []byte) error {
 	if i < 0 {
 		return errors.New("load code: no header")
 	}
-	if !headerPattern.Match(code[:i]) {
-		return errors.New("load code: invalid header")
+	h, err := parseHeader(string(code[:i]))
+	if err != nil {
+		return errors.New("load code: " + err.Error())
 	}
-	sub := headerPattern.FindStringSubmatch(string(code[:i]))
 	code = code[i+1:]
 
-	text, err := strconv.ParseInt(sub[1], 10, 32)
-	if err != nil {
-		panic(err)
-	}
 	// text segment can be empty, for online loading
-	if int(text) > len(code) {
+	if h.text > len(code) {
 		return errors.New("load code: text offset out of range")
 	}
-	data, err := strconv.ParseInt(sub[2], 10, 32)
-	if err != nil {
-		panic(err)
-	}
 	// data segment can be empty
-	if data < text || int(data) > len(code) {
+	if h.data < h.text || h.data > len(code) {
 		return errors.New("load code: data offset out of range")
 	}
+	if h.kdata > len(code) {
+		return errors.New("load code: kernel offset out of range")
+	}
+	l := e.machine.m.layout
+	if err := l.validate(); err != nil {
+		return errors.New("load code: " + err.Error())
+	}
+	if err := e.machine.m.checkDevices(); err != nil {
+		return errors.New("load code: " + err.Error())
+	}
+	if h.textAddr != l.Text || h.dataAddr != l.Data {
+		return fmt.Errorf("load code: assembled for text at %#x and data at %#x, "+
+			"not for the memory layout of the emulator", h.textAddr, h.dataAddr)
+	}
+	data := code[h.data:h.dataEnd(len(code))]
+	if uint64(h.data-h.text) > uint64(l.DataBase-l.Text) ||
+		uint64(len(data)) > uint64(l.DataLimit-l.Data) {
+		return errors.New("load code: segment too large")
+	}
 
-	err = e.machine.m.writeBytes(TEXT_ADDRESS, code[text:data])
+	err = e.machine.m.writeBytes(l.Text, code[h.text:h.data])
 	if err != nil {
 		return err
 	}
-	e.machine.m.writeBytes(DATA_ADDRESS, code[data:])
+	e.machine.m.order = h.order
+	e.machine.m.textEnd = l.Text + uint32(h.data-h.text)
+	err = e.machine.m.writeBytes(l.Data, data)
 	if err != nil {
 		return err
 	}
-
-	main, err := strconv.ParseInt(sub[3], 10, 32)
-	if err != nil {
-		panic(err)
+	// the heap starts after static data, aligned to a double word
+	e.machine.m.setHeap(l.Data + uint32(len(data)+7)&^7)
+	if h.hasKernel() {
+		err = e.machine.m.writeBytes(KTEXT_ADDRESS, code[h.ktext:h.kdata])
+		if err != nil {
+			return err
+		}
+		e.machine.m.ktextEnd = KTEXT_ADDRESS + uint32(h.kdata-h.ktext)
+		err = e.machine.m.writeBytes(KDATA_ADDRESS, code[h.kdata:])
+		if err != nil {
+			return err
+		}
 	}
-	if main < text || main >= data {
+	handler := h.hasKernel() && h.kdata-h.ktext > EXC_VECTOR-KTEXT_ADDRESS
+
+	// main may equal data only if text is empty, for online loading
+	if h.main < h.text || (h.main >= h.data && h.data > h.text) {
 		return errors.New("load code: main offset out of range")
 	}
-	e.machine.r.PC = TEXT_ADDRESS + int(main)
-	// stack pointer
-	e.machine.r.write(29, STACK_ADDRESS)
+	if uint64(len(e.cores)-1)*coreStack > uint64(l.SP-l.StackLimit) {
+		return errors.New("load code: stack too small for the cores")
+	}
+	for i, c := range e.cores {
+		c.handler = handler
+		c.r.PC = l.Text + uint32(h.main)
+		c.r.write(28, l.GP)
+		c.r.write(29, l.SP-uint32(i)*coreStack)
+	}
 	return nil
 }
 
-// resolve transfer 4 bytes into a execInst structure
-func resolve(s []byte) (*execInst, error) {
-	if len(s) != 4 {
-		return nil, errors.New("resolve: bad machine code")
-	}
-	raw := binary.LittleEndian.Uint32(s)
+// resolve transfer an instruction word into a execInst structure
+func resolve(raw uint32) (*execInst, error) {
 	// obtain instruction name
-	opcode := (raw >> 26) & 0x3F
-	var name string
-	var ok bool
-	switch opcode {
-	case 0:
-		funct := raw & 0x3F
-		name, ok = rInstructions[int(funct)]
-		if !ok {
-			return nil, errors.New("unsupported function code")
-		}
-	default:
-		name, ok = ijInstructions[int(opcode)]
-		if !ok {
-			return nil, errors.New("unsupported opcode")
-		}
-	}
-	isBranch := false
-	switch name {
-	case "beq", "bne", "j", "jr", "jal":
-		isBranch = true
+	name, err := lookup(raw)
+	if err != nil {
+		return nil, err
 	}
 
 	// resolve argument
@@ -317,7 +575,7 @@ func resolve(s []byte) (*execInst, error) {
 	var args []int
 	for i, j := 0, 0; i < len(inst.syntax) && j < len(inst.formats); {
 		switch {
-		case inst.syntax[i]&argReg != 0:
+		case inst.syntax[i]&(argReg|argCReg|argFReg) != 0:
 			var arg int
 			switch inst.formats[j] {
 			case fmtRegS:
@@ -326,6 +584,8 @@ func resolve(s []byte) (*execInst, error) {
 				arg = int((raw >> 16) & 0x1F)
 			case fmtRegD:
 				arg = int((raw >> 11) & 0x1F)
+			case fmtFd:
+				arg = int((raw >> 6) & 0x1F)
 			}
 			args = append(args, arg)
 			j++
@@ -338,6 +598,12 @@ func resolve(s []byte) (*execInst, error) {
 				arg = int(int16(raw & (0xFFFF)))
 			case fmtAddress:
 				arg = int(raw & 0x3FFFFFF)
+			case fmtExtSize:
+				arg = int((raw>>11)&0x1F) + 1
+			case fmtInsSize:
+				arg = int((raw>>11)&0x1F) - int((raw>>6)&0x1F) + 1
+			case fmtCode:
+				arg = int((raw >> 6) & 0x3FF)
 			}
 			args = append(args, arg)
 			j++
@@ -362,10 +628,9 @@ func resolve(s []byte) (*execInst, error) {
 		i++
 	}
 	return &execInst{
-		name:   name,
-		f:      funcTable[name],
-		args:   args,
-		branch: isBranch,
+		name: name,
+		f:    funcTable[name],
+		args: args,
 	}, nil
 }
 
@@ -373,18 +638,71 @@ func (e *Emulator) FetchSource(n int) ([]byte, error) {
 	if !e.running {
 		return nil, errors.New("Program is not running")
 	}
-	s, err := e.fetchRaw(n)
+	words, err := e.fetchRaw(n)
 	if err != nil {
 		return nil, err
 	}
-	return Disassemble(s)
+	return disasmWords(words)
 }
 
-func (e *Emulator) ReadMemory(addr int) (int, error) {
+func (e *Emulator) ReadMemory(addr uint32) (uint32, error) {
 	return e.machine.m.readWord(addr)
 }
 
-func (e *Emulator) ReadReg(reg string) (int, error) {
+// Heap returns the start of the heap and the current break
+func (e *Emulator) Heap() (start, brk uint32) {
+	return e.machine.m.heapStart, e.machine.m.brk
+}
+
+// Map maps the pages in [addr, addr+size) with permissions perm, which
+// must not be zero. addr and size must be multiples of 4 KB. Mapped
+// pages replace those of segments.
+func (e *Emulator) Map(addr, size uint32, perm Perm) error {
+	if perm == 0 {
+		return errors.New("map: no permissions")
+	}
+	return e.machine.m.mapPages(addr, size, perm)
+}
+
+// Unmap unmaps the pages in [addr, addr+size) and frees them, so that
+// accessing them faults.
+func (e *Emulator) Unmap(addr, size uint32) error {
+	return e.machine.m.mapPages(addr, size, 0)
+}
+
+// MemoryStats reports the memory allocated for the program
+func (e *Emulator) MemoryStats() MemoryStats {
+	return e.machine.m.stats()
+}
+
+// Cores returns the number of cores
+func (e *Emulator) Cores() int {
+	return len(e.cores)
+}
+
+// Core returns the ID of the current core, whose registers ReadReg and
+// WriteReg access, and which runs next
+func (e *Emulator) Core() int {
+	return e.machine.id
+}
+
+// SelectCore makes core id the current core
+func (e *Emulator) SelectCore(id int) error {
+	if id < 0 || id >= len(e.cores) {
+		return fmt.Errorf("no core %d", id)
+	}
+	e.machine = e.cores[id]
+	e.left = e.quantum
+	return nil
+}
+
+// InDelaySlot reports whether the instruction at PC is in the delay
+// slot of a taken branch.
+func (e *Emulator) InDelaySlot() bool {
+	return e.machine.inSlot
+}
+
+func (e *Emulator) ReadReg(reg string) (uint32, error) {
 	switch reg {
 	case "PC":
 		return e.machine.r.PC, nil
@@ -392,7 +710,25 @@ func (e *Emulator) ReadReg(reg string) (int, error) {
 		return e.machine.r.LO, nil
 	case "HI":
 		return e.machine.r.HI, nil
+	case "BadVAddr":
+		return e.machine.c0[c0BadVAddr], nil
+	case "Count":
+		return e.machine.c0[c0Count], nil
+	case "Compare":
+		return e.machine.c0[c0Compare], nil
+	case "Status":
+		return e.machine.c0[c0Status], nil
+	case "Cause":
+		return e.machine.c0[c0Cause], nil
+	case "EPC":
+		return e.machine.c0[c0EPC], nil
+	case "FCSR":
+		return e.machine.r.fcsr, nil
 	default:
+		if id, ok := fpRegisterTable[reg]; ok {
+			// raw bits of the floating-point register
+			return e.machine.r.fpr[id], nil
+		}
 		var id int
 		var ok bool
 		if id, ok = registerTable[reg]; !ok {
@@ -401,3 +737,62 @@ func (e *Emulator) ReadReg(reg string) (int, error) {
 		return e.machine.r.read(id), nil
 	}
 }
+
+// WriteReg writes a register named as in ReadReg. Read-only bits of
+// coprocessor 0 registers are kept.
+func (e *Emulator) WriteReg(reg string, value uint32) error {
+	switch reg {
+	case "PC":
+		e.machine.r.PC = value
+	case "LO":
+		e.machine.r.LO = value
+	case "HI":
+		e.machine.r.HI = value
+	case "BadVAddr":
+		e.machine.writeC0(c0BadVAddr, value)
+	case "Count":
+		e.machine.writeC0(c0Count, value)
+	case "Compare":
+		e.machine.writeC0(c0Compare, value)
+	case "Status":
+		e.machine.writeC0(c0Status, value)
+	case "Cause":
+		e.machine.writeC0(c0Cause, value)
+	case "EPC":
+		e.machine.writeC0(c0EPC, value)
+	case "FCSR":
+		e.machine.r.fcsr = value
+	default:
+		if id, ok := fpRegisterTable[reg]; ok {
+			e.machine.r.fpr[id] = value
+			return nil
+		}
+		id, ok := registerTable[reg]
+		if !ok {
+			return errors.New("write register '" + reg + "':no such register")
+		}
+		e.machine.r.write(id, value)
+	}
+	return nil
+}
+
+// WriteMemory writes a word at addr
+func (e *Emulator) WriteMemory(addr uint32, value uint32) error {
+	return e.machine.m.writeWord(addr, value)
+}
+
+// ReadString reads the null-terminated string at addr
+func (e *Emulator) ReadString(addr uint32) (string, error) {
+	var buf []byte
+	for {
+		b, err := e.machine.m.read(addr)
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(buf), nil
+		}
+		buf = append(buf, b)
+		addr++
+	}
+}
@@ -2,10 +2,13 @@ package mips
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"log"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestEmulator(t *testing.T) {
@@ -41,8 +44,540 @@ func TestEmulator(t *testing.T) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = em.Wait()
+}
+
+const loopProgram = `.text
+main:
+	li $t0, 0
+	li $t1, 100000
+loop:
+	addi $t0, $t0, 1
+	bne $t0, $t1, loop
+	li $v0, 10
+	syscall`
+
+func assembleString(t testing.TB, s string) []byte {
+	raw, err := NewAssembler(strings.NewReader(s)).Assemble()
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestRun(t *testing.T) {
+	em := NewEmulator()
+	if err := em.Load(assembleString(t, loopProgram)); err != nil {
+		t.Fatal(err)
+	}
+	status, err := em.Run(context.Background())
+	if status != EXIT_NORMAL || err != nil {
+		t.Fatalf("expect EXIT_NORMAL, got %s(%v)", status, err)
+	}
+	if v, _ := em.ReadReg("t0"); v != 100000 {
+		t.Errorf("expect $t0 = 100000, got %d", v)
+	}
+}
+
+func TestRunStop(t *testing.T) {
+	raw := assembleString(t, ".text\nmain:\n\tj main")
+
+	em := NewEmulator()
+	em.Load(raw)
+	em.SetTimer(10 * time.Millisecond)
+	if status, err := em.Run(context.Background()); status != EXIT_TIMEOUT || err == nil {
+		t.Errorf("timer: expect EXIT_TIMEOUT, got %s(%v)", status, err)
+	}
+
+	em = NewEmulator()
+	em.Load(raw)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if status, err := em.Run(ctx); status != EXIT_TIMEOUT || err != context.DeadlineExceeded {
+		t.Errorf("deadline: expect EXIT_TIMEOUT, got %s(%v)", status, err)
+	}
+
+	em = NewEmulator()
+	em.Load(raw)
+	time.AfterFunc(10*time.Millisecond, em.Exit)
+	if status, err := em.Run(context.Background()); status != EXIT_INT || err != nil {
+		t.Errorf("exit: expect EXIT_INT, got %s(%v)", status, err)
+	}
+}
+
+func TestStep(t *testing.T) {
+	em := NewEmulator()
+	if err := em.LoadAndStart(assembleString(t, loopProgram)); err != nil {
+		t.Fatal(err)
+	}
+	// li expands to lui+ori
+	for i := 0; i < 4; i++ {
+		if err := em.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pc, _ := em.ReadReg("PC"); pc != 16 {
+		t.Errorf("expect PC = 16, got %d", pc)
+	}
+	if v, _ := em.ReadReg("t1"); v != 100000 {
+		t.Errorf("expect $t1 = 100000, got %d", v)
+	}
+}
+
+func TestSelfModifyingCode(t *testing.T) {
+	// The second iteration executes the patched "addi $t0, $t0, 100".
+	em := NewEmulator(WithWritableText())
+	if err := em.Load(assembleString(t, `.text
+main:
+	li $t2, 2
+loop:
+	addi $t0, $t0, 1
+	la $t3, loop
+	li $t4, 0x21080064
+	sw $t4, 0($t3)
+	addi $t2, $t2, -1
+	bne $t2, $zero, loop
+	li $v0, 10
+	syscall`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := em.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadReg("t0"); v != 101 {
+		t.Errorf("expect $t0 = 101, got %d", v)
+	}
+}
+
+func TestMemoryProtection(t *testing.T) {
+	tests := []struct {
+		src    string
+		opts   []Option
+		status ExitStatus
+		code   ExcCode
+		addr   uint32
+	}{
+		// store to text
+		{"sw $zero, 4($zero)", nil, EXIT_ERROR, EXC_ADES, 4},
+		{"sw $zero, 4($zero)", []Option{WithWritableText()}, EXIT_EOF, 0, 0},
+		{"swr $zero, 5($zero)", nil, EXIT_ERROR, EXC_ADES, 5},
+		// fetch from data, and from text that is not loaded
+		{"lui $t0, 0x400\n\tjr $t0", nil, EXIT_ERROR, EXC_IBE, DATA_ADDRESS},
+		{"li $t0, 0x100\n\tjr $t0", nil, EXIT_ERROR, EXC_IBE, 0x100},
+		// fall off the end of text
+		{"nop", nil, EXIT_EOF, 0, 0},
+	}
+	for _, tt := range tests {
+		em := NewEmulator(tt.opts...)
+		if err := em.Load(assembleString(t, "main:\n\t"+tt.src)); err != nil {
+			t.Fatal(err)
+		}
+		status, err := em.Run(context.Background())
+		if status != tt.status {
+			t.Errorf("%q: expect %v, got %v: %v", tt.src, tt.status, status, err)
+			continue
+		}
+		if tt.status != EXIT_ERROR {
+			continue
+		}
+		exc, ok := err.(*Exception)
+		if !ok || exc.Code != tt.code || exc.BadVAddr != tt.addr {
+			t.Errorf("%q: expect exception %d at address %#x, got %v",
+				tt.src, tt.code, tt.addr, err)
+		}
+	}
+}
+
+const delaySlotProgram = `.text
+main:
+	li $t0, 100
+	jal skip
+	addi $t0, $t0, 1
+	addi $t0, $t0, 10
+skip:
+	li $v0, 10
+	syscall`
+
+func TestBranchDelay(t *testing.T) {
+	tests := []struct {
+		opts   []Option
+		t0, ra uint32
+	}{
+		{nil, 100, 0xC},
+		{[]Option{WithBranchDelay()}, 101, 0x10},
+	}
+	raw := assembleString(t, delaySlotProgram)
+	for _, tt := range tests {
+		em := NewEmulator(tt.opts...)
+		if err := em.LoadAndRun(raw); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := em.ReadReg("t0"); v != tt.t0 {
+			t.Errorf("expect $t0 = %d, got %d", tt.t0, v)
+		}
+		if v, _ := em.ReadReg("ra"); v != tt.ra {
+			t.Errorf("expect $ra = %#x, got %#x", tt.ra, v)
+		}
+	}
+}
+
+func TestInDelaySlot(t *testing.T) {
+	em := NewEmulator(WithBranchDelay())
+	if err := em.LoadAndStart(assembleString(t, delaySlotProgram)); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{false, false, false, true, false} {
+		if em.InDelaySlot() != want {
+			t.Errorf("step %d: expect InDelaySlot() = %v", i, want)
+		}
+		if err := em.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pc, _ := em.ReadReg("PC"); pc != 0x18 {
+		t.Errorf("expect PC = 0x18, got %#x", pc)
+	}
+}
+
+func TestLoadDelay(t *testing.T) {
+	src := `.data
+x:	.word 7
+.text
+main:
+	la $t1, x
+	li $t0, 1
+	lw $t0, 0($t1)
+	move $t2, $t0
+	move $t3, $t0
+	lw $t4, 0($t1)
+	addi $t4, $zero, 1
+	li $v0, 10
+	syscall`
+	tests := []struct {
+		opts   []Option
+		t2, t3 uint32
+	}{
+		{nil, 7, 7},
+		{[]Option{WithLoadDelay()}, 1, 7},
+	}
+	raw := assembleString(t, src)
+	for _, tt := range tests {
+		em := NewEmulator(tt.opts...)
+		if err := em.LoadAndRun(raw); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := em.ReadReg("t2"); v != tt.t2 {
+			t.Errorf("expect $t2 = %d, got %d", tt.t2, v)
+		}
+		if v, _ := em.ReadReg("t3"); v != tt.t3 {
+			t.Errorf("expect $t3 = %d, got %d", tt.t3, v)
+		}
+		// the write in the delay slot wins over the load
+		if v, _ := em.ReadReg("t4"); v != 1 {
+			t.Errorf("expect $t4 = 1, got %d", v)
+		}
+	}
+}
+
+func TestSetReorder(t *testing.T) {
+	// Filled slots make the program behave the same with or without
+	// branch and load delay; the noreorder part relies on its slot.
+	src := `.data
+x:	.word 5
+.text
+main:
+	.set reorder
+	li $t0, 3
+loop:
+	addi $t1, $t1, 1
+	addi $t0, $t0, -1
+	bgtz $t0, loop
+	la $t2, x
+	lw $t3, 0($t2)
+	move $t4, $t3
+	.set noreorder
+	j done
+	addi $t1, $t1, 10
+	addi $t1, $t1, 100
+done:
+	li $v0, 10
+	syscall`
+	raw := assembleString(t, src)
+	em := NewEmulator(WithBranchDelay(), WithLoadDelay())
+	if err := em.LoadAndRun(raw); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadReg("t1"); v != 13 {
+		t.Errorf("expect $t1 = 13, got %d", v)
+	}
+	if v, _ := em.ReadReg("t4"); v != 5 {
+		t.Errorf("expect $t4 = 5, got %d", v)
+	}
+	d, err := NewDisassembler(bytes.NewReader(raw)).Disassemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(d, []byte("nop")); n != 2 {
+		t.Errorf("expect 2 filled delay slots, got %d:\n%s", n, d)
+	}
+}
+
+func TestFetchOnline(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		em := NewEmulator(WithByteOrder(order))
+		if err := em.StartOnline(); err != nil {
+			t.Fatal(err)
+		}
+		code := [][]byte{
+			[]byte("\x01\x00\x08\x21"), // addi $t0, $t0, 1
+			[]byte("\x00\x00\x00\x08"), // j 0
+			[]byte("\x05\x00\x08\x21"), // addi $t0, $t0, 5
+		}
+		for _, c := range code {
+			if err := em.FetchOnline(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if v, _ := em.ReadReg("t0"); v != 6 {
+			t.Errorf("%v: expect $t0 = 6, got %d", order, v)
+		}
+	}
+}
+
+const fibProgram = `.text
+main:
+	li $a0, 20
+	jal fib
+	move $s1, $v0
+	li $v0, 10
+	syscall
+fib:
+	addi $sp, $sp, -12
+	sw $a0, 8($sp)
+	sw $s0, 4($sp)
+	sw $ra, 0($sp)
+	slti $t0, $a0, 2
+	bne $t0, $zero, return_1
+	addi $a0, $a0, -1
+	jal fib
+	move $s0, $v0
+	addi $a0, $a0, -1
+	jal fib
+	add $v0, $s0, $v0
+	j fib_return
+return_1:
+	li $v0, 1
+fib_return:
+	lw $a0, 8($sp)
+	lw $s0, 4($sp)
+	lw $ra, 0($sp)
+	addi $sp, $sp, 12
+	jr $ra`
+
+func BenchmarkFib(b *testing.B) {
+	raw := assembleString(b, fibProgram)
+	for i := 0; i < b.N; i++ {
+		em := NewEmulator()
+		if err := em.Load(raw); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := em.Run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+		if v, _ := em.ReadReg("s1"); v != 10946 {
+			b.Fatalf("expect fib(20) = 10946, got %d", v)
+		}
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	raw := assembleString(b, loopProgram)
+	for i := 0; i < b.N; i++ {
+		em := NewEmulator()
+		if err := em.Load(raw); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := em.Run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	raw := assembleString(b, ".text\nmain:\n\tj main")
+	em := NewEmulator()
+	if err := em.LoadAndStart(raw); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := em.Step(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBigEndian(t *testing.T) {
+	src := `.data
+w:	.word 0x11223344
+h:	.half 0x5566
+	.align 3
+d:	.double 1.5
+	.space 4
+.text
+main:
+	la $t0, w
+	lb $s0, 0($t0)
+	lhu $s1, 4($t0)
+	lwl $s2, 1($t0)
+	lwr $s3, 1($t0)
+	ldc1 $f2, 8($t0)
+	sb $s0, 16($t0)
+	li $t1, 0x778899AA
+	swl $t1, 17($t0)
+	li $v0, 10
+	syscall`
+	a := NewAssembler(strings.NewReader(src))
+	a.SetByteOrder(binary.BigEndian)
+	raw, err := a.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.IndexByte(raw, '\n')
+	if !bytes.HasSuffix(raw[:i], []byte(",endian:big")) {
+		t.Errorf("expect the byte order in the header, got %q", raw[:i])
+	}
+	em := NewEmulator()
+	if err := em.LoadAndRun(raw); err != nil {
+		t.Fatal(err)
+	}
+	for reg, want := range map[string]uint32{
+		"s0": 0x11,
+		"s1": 0x5566,
+		"s2": 0x22334400,
+		"s3": 0x00001122,
+		"f2": 0,
+		"f3": 0x3FF80000,
+	} {
+		if v, _ := em.ReadReg(reg); v != want {
+			t.Errorf("expect $%s = %#x, got %#x", reg, want, v)
+		}
+	}
+	for addr, want := range map[uint32]uint32{
+		DATA_ADDRESS:      0x11223344,
+		DATA_ADDRESS + 16: 0x11778899,
+	} {
+		if v, _ := em.ReadMemory(addr); v != want {
+			t.Errorf("expect %#x at %#x, got %#x", want, addr, v)
+		}
+	}
+
+	d, err := NewDisassembler(bytes.NewReader(raw)).Disassemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := NewDisassembler(bytes.NewReader(assembleString(t, src))).Disassemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d, want) {
+		t.Errorf("expect the same code in both byte orders, got:\n%s\nwant:\n%s", d, want)
+	}
+}
+
+const coresProgram = `.data
+count:	.word 0
+done:	.word 0
+ids:	.space 16
+.text
+main:
+	rdhwr $s0, $0
+	la $t2, ids
+	sll $t4, $s0, 2
+	addu $t2, $t2, $t4
+	addiu $t5, $s0, 1
+	sw $t5, 0($t2)
+	la $t0, count
+	li $t3, 100
+inc:
+%s
+	addiu $t3, $t3, -1
+	bnez $t3, inc
+	la $t0, done
+incdone:
+	ll $t1, 0($t0)
+	addiu $t1, $t1, 1
+	sc $t1, 0($t0)
+	beqz $t1, incdone
+	bnez $s0, spin
+wait:
+	lw $t1, 0($t0)
+	slti $t1, $t1, 4
+	bnez $t1, wait
+	li $v0, 10
+	syscall
+spin:
+	j spin`
+
+func TestCores(t *testing.T) {
+	atomic := `retry:
+	ll $t1, 0($t0)
+	addiu $t1, $t1, 1
+	sc $t1, 0($t0)
+	beqz $t1, retry`
+	racy := `	lw $t1, 0($t0)
+	addiu $t1, $t1, 1
+	sw $t1, 0($t0)`
+	run := func(inc string) uint32 {
+		em := NewEmulator(WithCores(4), WithScheduler(42, 1))
+		if em.Cores() != 4 {
+			t.Fatalf("expect 4 cores, got %d", em.Cores())
+		}
+		if err := em.LoadAndRun(assembleString(t, fmt.Sprintf(coresProgram, inc))); err != nil {
+			t.Fatal(err)
+		}
+		for i := uint32(0); i < 4; i++ {
+			if v, _ := em.ReadMemory(DATA_ADDRESS + 8 + 4*i); v != i+1 {
+				t.Errorf("expect core %d to store %d, got %d", i, i+1, v)
+			}
+		}
+		count, _ := em.ReadMemory(DATA_ADDRESS)
+		return count
+	}
+	if n := run(atomic); n != 400 {
+		t.Errorf("expect 400 atomic increments, got %d", n)
+	}
+	n := run(racy)
+	if n >= 400 {
+		t.Errorf("expect racy increments to be lost, got %d", n)
+	}
+	if n2 := run(racy); n2 != n {
+		t.Errorf("expect the same interleaving from the same seed, got %d and %d", n, n2)
+	}
+}
+
+func TestSelectCore(t *testing.T) {
+	em := NewEmulator(WithCores(2))
+	if err := em.LoadAndStart(assembleString(t, "main:\n\trdhwr $s0, $0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.SelectCore(2); err == nil {
+		t.Error("expect an error selecting core 2 of 2")
+	}
+	if err := em.SelectCore(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadReg("s0"); em.Core() != 1 || v != 1 {
+		t.Errorf("expect core 1 to read its ID, got %d on core %d", v, em.Core())
+	}
+	sp0, _ := em.ReadReg("sp")
+	em.SelectCore(0)
+	if v, _ := em.ReadReg("s0"); v != 0 {
+		t.Errorf("expect core 0 not to run, got $s0 = %d", v)
+	}
+	if sp, _ := em.ReadReg("sp"); sp-sp0 != coreStack {
+		t.Errorf("expect the stack of core 1 below that of core 0, got %#x and %#x", sp0, sp)
 	}
 }
@@ -0,0 +1,163 @@
+package mips
+
+import "fmt"
+
+// ExcCode is the exception code recorded in the Cause register
+//go:generate stringer -type=ExcCode
+type ExcCode int
+
+const (
+	EXC_INT  ExcCode = 0  // interrupt
+	EXC_ADEL ExcCode = 4  // address error on load or instruction fetch
+	EXC_ADES ExcCode = 5  // address error on store
+	EXC_IBE  ExcCode = 6  // bus error on instruction fetch
+	EXC_DBE  ExcCode = 7  // bus error on load or store
+	EXC_SYS  ExcCode = 8  // syscall
+	EXC_BP   ExcCode = 9  // breakpoint
+	EXC_RI   ExcCode = 10 // reserved instruction
+	EXC_CPU  ExcCode = 11 // coprocessor unusable
+	EXC_OV   ExcCode = 12 // arithmetic overflow
+	EXC_TR   ExcCode = 13 // trap
+)
+
+// coprocessor 0 registers
+const (
+	c0BadVAddr = 8
+	c0Count    = 9
+	c0Compare  = 11
+	c0Status   = 12
+	c0Cause    = 13
+	c0EPC      = 14
+)
+
+// bits of the Status and Cause registers
+const (
+	statusIE     = 1 << 0 // interrupt enable
+	statusEXL    = 1 << 1 // exception level
+	statusUM     = 1 << 4 // user mode
+	statusIM     = 0xFF << 8
+	causeExcCode = 0x1F << 2
+	causeIPSoft  = 0x3 << 8   // software interrupts, writable
+	causeIPHard  = 0x3F << 10 // hardware interrupts 0 to 5
+	causeTI      = 1 << 30    // timer interrupt, Count reached Compare
+	causeBD      = 1 << 31    // exception in branch delay slot
+	// Status at program start: user mode with interrupts enabled
+	statusInit = statusIM | statusUM | statusIE
+)
+
+var excMessages = map[ExcCode]string{
+	EXC_INT:  "Interrupt",
+	EXC_ADEL: "Address error in inst/data fetch",
+	EXC_ADES: "Address error in store",
+	EXC_IBE:  "Bad instruction address",
+	EXC_DBE:  "Bad data address",
+	EXC_SYS:  "Error in syscall",
+	EXC_BP:   "Breakpoint",
+	EXC_RI:   "Reserved instruction",
+	EXC_CPU:  "Coprocessor unusable",
+	EXC_OV:   "Arithmetic overflow",
+	EXC_TR:   "Trap",
+}
+
+// Exception is a fault raised while executing an instruction
+type Exception struct {
+	Code     ExcCode
+	PC       uint32 // address of the faulting instruction
+	BadVAddr uint32 // faulting address of address and bus errors
+	TrapCode int    // code field of the trap instruction raising EXC_TR
+}
+
+func (e *Exception) Error() string {
+	msg := fmt.Sprintf("exception %d [%s] at %#x", e.Code, excMessages[e.Code], e.PC)
+	switch e.Code {
+	case EXC_ADEL, EXC_ADES, EXC_IBE, EXC_DBE:
+		msg += fmt.Sprintf(": address %#x", e.BadVAddr)
+	case EXC_TR:
+		msg += fmt.Sprintf(": code %d", e.TrapCode)
+	}
+	return msg
+}
+
+// raise aborts the current instruction with an exception
+func (m *Machine) raise(code ExcCode, badVAddr uint32) {
+	panic(&Exception{
+		Code:     code,
+		PC:       m.r.PC,
+		BadVAddr: badVAddr,
+	})
+}
+
+// trap raises a trap exception carrying code
+func (m *Machine) trap(code int) {
+	panic(&Exception{
+		Code:     EXC_TR,
+		PC:       m.r.PC,
+		TrapCode: code,
+	})
+}
+
+// privileged raises a coprocessor unusable exception unless m runs in
+// kernel mode, which coprocessor 0 instructions require
+func (m *Machine) privileged() {
+	if !m.kernelMode() {
+		m.raise(EXC_CPU, 0)
+	}
+}
+
+// timerIRQ is the hardware interrupt of the timer
+const timerIRQ = 1 << 5
+
+// interrupt advances Count by an instruction, updates the timer
+// interrupt, and takes an interrupt exception if an enabled one is
+// pending and the exception handler is loaded. Count counts
+// instructions, so that interrupts are reproducible.
+func (m *Machine) interrupt() {
+	m.c0[c0Count]++
+	if m.c0[c0Count] == m.c0[c0Compare] {
+		m.c0[c0Cause] |= causeTI
+	}
+	m.c0[c0Cause] &^= timerIRQ << 10
+	if m.c0[c0Cause]&causeTI != 0 {
+		m.c0[c0Cause] |= timerIRQ << 10
+	}
+	status := m.c0[c0Status]
+	if status&(statusIE|statusEXL) == statusIE && m.handler &&
+		m.c0[c0Cause]&status&statusIM != 0 {
+		m.raise(EXC_INT, 0)
+	}
+}
+
+// enterException records exc in coprocessor 0 and cancels the control
+// transfer of the faulting instruction. If it faulted in a delay slot,
+// EPC points to the branch so that the branch is executed again.
+func (m *Machine) enterException(exc *Exception) {
+	m.c0[c0EPC] = exc.PC
+	m.c0[c0Cause] = m.c0[c0Cause]&^(causeExcCode|causeBD) | uint32(exc.Code)<<2
+	if m.inSlot {
+		m.c0[c0EPC] -= 4
+		m.c0[c0Cause] |= causeBD
+	}
+	m.jump, m.inSlot, m.llBit = false, false, false
+	m.retireLoad()
+	m.load = pendingLoad{}
+	switch exc.Code {
+	case EXC_ADEL, EXC_ADES, EXC_IBE, EXC_DBE:
+		m.c0[c0BadVAddr] = exc.BadVAddr
+	}
+	m.c0[c0Status] |= statusEXL
+}
+
+// writeC0 writes a coprocessor 0 register, keeping read-only bits
+func (m *Machine) writeC0(id int, value uint32) {
+	switch id {
+	case c0BadVAddr:
+	case c0Compare:
+		// acknowledges the timer interrupt
+		m.c0[c0Compare] = value
+		m.c0[c0Cause] &^= causeTI
+	case c0Cause:
+		m.c0[c0Cause] = m.c0[c0Cause]&^causeIPSoft | value&causeIPSoft
+	default:
+		m.c0[id] = value
+	}
+}
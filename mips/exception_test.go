@@ -0,0 +1,287 @@
+package mips
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+var exceptionTests = []struct {
+	src      string // faulting instruction, preceded by li $t0, 0x7FFFFFFF
+	code     ExcCode
+	badVAddr uint32
+}{
+	{"add $t1, $t0, $t0", EXC_OV, 0},
+	{"addi $t1, $t0, 1", EXC_OV, 0},
+	{"li $t2, -2\n\tsub $t1, $t0, $t2", EXC_OV, 0},
+	{"lw $t1, 2($sp)", EXC_ADEL, STACK_ADDRESS + 2},
+	{"lh $t1, -1($sp)", EXC_ADEL, STACK_ADDRESS - 1},
+	{"sw $t1, 1($sp)", EXC_ADES, STACK_ADDRESS + 1},
+	{"sh $t1, 3($sp)", EXC_ADES, STACK_ADDRESS + 3},
+	{"lw $t1, 0($t0)", EXC_ADEL, 0x7FFFFFFF},
+	{"li $t2, 0x7F001000\n\tlw $t1, 0($t2)", EXC_DBE, 0x7F001000},
+	{"jr $t0", EXC_ADEL, 0x7FFFFFFF},
+	{"li $t2, 0x90000000\n\tlw $t1, 0($t2)", EXC_ADEL, 0x90000000},
+	{"li $t2, 0x80000000\n\tjr $t2", EXC_ADEL, 0x80000000},
+	{"li $t2, 0x80000001\n\tswl $t1, 0($t2)", EXC_ADES, 0x80000001},
+	{"li $v0, 1000\n\tsyscall", EXC_SYS, 0},
+	{"break", EXC_BP, 0},
+	{".word 0xFC000000", EXC_RI, 0},
+	{".word 0x46000132", EXC_RI, 0}, // c.eq.s on condition code 1
+	{".word 0x45050000", EXC_RI, 0}, // bc1t on condition code 1
+	{"teq $t1, $t1", EXC_TR, 0},
+	{"tgeiu $t0, -1\n\ttltu $t1, $t0, 3", EXC_TR, 0},
+	{"tnei $t1, 41", EXC_TR, 0},
+	{"mfc0 $t1, $12", EXC_CPU, 0},
+	{"mtc0 $t1, $12", EXC_CPU, 0},
+	{"eret", EXC_CPU, 0},
+}
+
+func TestTrap(t *testing.T) {
+	// Only the last check fails
+	em := NewEmulator()
+	err := em.LoadAndRun(assembleString(t, `.text
+main:
+	li $t0, -1
+	li $t1, 1
+	tge $t0, $t1
+	tgeu $t1, $t0, 1
+	tlt $t1, $t0, 2
+	tlti $t1, -1
+	teqi $t0, 1
+	tne $t1, $t1
+	tltiu $t0, 5
+	tlt $t0, $t1, 1023
+	li $v0, 10
+	syscall`))
+	exc, ok := err.(*Exception)
+	if !ok || exc.Code != EXC_TR {
+		t.Fatalf("expect a trap, got %v", err)
+	}
+	if exc.TrapCode != 1023 || exc.PC != 0x2C {
+		t.Errorf("expect code 1023 at 0x2c, got %d at %#x", exc.TrapCode, exc.PC)
+	}
+	if want := "exception 13 [Trap] at 0x2c: code 1023"; exc.Error() != want {
+		t.Errorf("expect %q, got %q", want, exc.Error())
+	}
+}
+
+func TestException(t *testing.T) {
+	for _, tt := range exceptionTests {
+		em := NewEmulator()
+		src := ".text\nmain:\n\tli $t0, 0x7FFFFFFF\n\tli $t1, 42\n\t" + tt.src
+		if err := em.Load(assembleString(t, src)); err != nil {
+			t.Fatal(err)
+		}
+		status, err := em.Run(context.Background())
+		exc, ok := err.(*Exception)
+		if status != EXIT_ERROR || !ok {
+			t.Errorf("%s: expect an exception, got %s(%v)", tt.src, status, err)
+			continue
+		}
+		if exc.Code != tt.code {
+			t.Errorf("%s: expect %s, got %s", tt.src, tt.code, exc.Code)
+		}
+		if exc.BadVAddr != tt.badVAddr {
+			t.Errorf("%s: expect bad address %#x, got %#x",
+				tt.src, tt.badVAddr, exc.BadVAddr)
+		}
+		if epc, _ := em.ReadReg("EPC"); epc != exc.PC {
+			t.Errorf("%s: expect EPC = %#x, got %#x", tt.src, exc.PC, epc)
+		}
+		if cause, _ := em.ReadReg("Cause"); ExcCode(cause>>2&0x1F) != tt.code {
+			t.Errorf("%s: expect Cause = %s, got %#x", tt.src, tt.code, cause)
+		}
+		if v, _ := em.ReadReg("t1"); v != 42 && tt.code == EXC_OV {
+			t.Errorf("%s: destination was written: %#x", tt.src, v)
+		}
+	}
+}
+
+func TestDivideByZero(t *testing.T) {
+	em := NewEmulator()
+	src := `.text
+main:
+	li $t0, 7
+	div $t0, $zero
+	divu $t0, $zero
+	li $v0, 10
+	syscall`
+	if err := em.LoadAndRun(assembleString(t, src)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoprocessor0(t *testing.T) {
+	em := NewEmulator()
+	em.WriteReg("Status", statusInit&^statusUM) // kernel mode
+	src := `.text
+main:
+	la $t0, resume
+	mtc0 $t0, $14
+	mfc0 $t1, $14
+	mfc0 $t2, $12
+	ori $t3, $t2, 2
+	mtc0 $t3, $12
+	eret
+	li $t1, 0
+resume:
+	li $v0, 10
+	syscall`
+	if err := em.LoadAndRun(assembleString(t, src)); err != nil {
+		t.Fatal(err)
+	}
+	resume, _ := em.ReadReg("t0")
+	if v, _ := em.ReadReg("t1"); v != resume {
+		t.Errorf("expect $t1 = %#x, got %#x", resume, v)
+	}
+	if v, _ := em.ReadReg("Status"); v&statusEXL != 0 {
+		t.Errorf("eret should clear EXL, Status = %#x", v)
+	}
+}
+
+func TestExceptionHandler(t *testing.T) {
+	src := `.text
+main:
+	li $t0, 0x7FFFFFFF
+	addi $t1, $t0, 1
+	break
+	li $v0, 10
+	syscall
+
+.kdata
+saved: .word 0
+.ktext 0x80000180
+	la $k0, saved
+	sw $t0, 0($k0)
+	addi $k1, $k1, 1
+	mfc0 $k0, $14
+	addiu $k0, $k0, 4
+	mtc0 $k0, $14
+	eret`
+	raw := assembleString(t, src)
+	em := NewEmulator()
+	if err := em.LoadAndRun(raw); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadReg("k1"); v != 2 {
+		t.Errorf("expect the handler to run twice, got %d", v)
+	}
+	if v, _ := em.ReadMemory(KDATA_ADDRESS); v != 0x7FFFFFFF {
+		t.Errorf("expect kdata word %#x, got %#x", 0x7FFFFFFF, v)
+	}
+	if v, _ := em.ReadReg("Cause"); ExcCode(v>>2&0x1F) != EXC_BP {
+		t.Errorf("expect Cause = %s, got %#x", EXC_BP, v)
+	}
+
+	d, err := NewDisassembler(bytes.NewReader(raw)).Disassemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(d, []byte("mtc0\t$k0, $14\neret")) {
+		t.Errorf("unexpected kernel disassembly:\n%s", d)
+	}
+}
+
+func TestKernelCodeFromUserMode(t *testing.T) {
+	// Once the handler has run, and so is cached, user code jumps to it.
+	// The fetch must still fault, entering the handler a second time.
+	src := `.text
+main:
+	break
+	li $t0, 0x80000180
+	jr $t0
+	li $v0, 10
+	syscall
+
+.ktext 0x80000180
+	addi $s1, $s1, 1
+	mfc0 $s0, $13
+	li $k1, 2
+	beq $s1, $k1, stop
+	mfc0 $k0, $14
+	addiu $k0, $k0, 4
+	mtc0 $k0, $14
+	eret
+stop:
+	li $v0, 10
+	syscall`
+	em := NewEmulator(WithLimits(Limits{Instructions: 1000}))
+	if err := em.LoadAndRun(assembleString(t, src)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadReg("s1"); v != 2 {
+		t.Errorf("expect the handler to run twice, got %d", v)
+	}
+	if v, _ := em.ReadReg("s0"); ExcCode(v>>2&0x1F) != EXC_ADEL {
+		t.Errorf("expect Cause = %s for the jump, got %#x", EXC_ADEL, v)
+	}
+	if v, _ := em.ReadReg("BadVAddr"); v != EXC_VECTOR {
+		t.Errorf("expect BadVAddr = %#x, got %#x", EXC_VECTOR, v)
+	}
+}
+
+func TestKernelOnly(t *testing.T) {
+	raw := assembleString(t, ".ktext 0x80000180\n\teret")
+	if !bytes.HasPrefix(raw, []byte("text:0,data:0,main:0,ktext:0,kdata:388\n")) {
+		t.Errorf("expect kernel offsets in the header, got %q", raw)
+	}
+	em := NewEmulator()
+	if err := em.Load(raw); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadMemory(EXC_VECTOR); v != 0x42000018 {
+		t.Errorf("expect eret at the exception vector, got %#x", v)
+	}
+}
+
+func TestExceptionInDelaySlot(t *testing.T) {
+	src := `.text
+main:
+	li $t0, 0x7FFFFFFF
+	j next
+	addi $t1, $t0, 1
+next:
+	li $v0, 10
+	syscall`
+	em := NewEmulator(WithBranchDelay())
+	if err := em.LoadAndRun(assembleString(t, src)); err == nil {
+		t.Fatal("expect an overflow exception")
+	}
+	if v, _ := em.ReadReg("Cause"); v&causeBD == 0 || ExcCode(v>>2&0x1F) != EXC_OV {
+		t.Errorf("expect Cause = BD|%s, got %#x", EXC_OV, v)
+	}
+	if v, _ := em.ReadReg("EPC"); v != 0x8 {
+		t.Errorf("expect EPC at the jump 0x8, got %#x", v)
+	}
+}
+
+func TestTimerInterrupt(t *testing.T) {
+	src := `.text
+main:
+loop:
+	slti $t1, $s1, 3
+	bnez $t1, loop
+	li $v0, 10
+	syscall
+
+.ktext 0x80000180
+	mfc0 $s3, $9
+	mfc0 $k0, $11
+	addiu $k0, $k0, 50
+	mtc0 $k0, $11
+	addi $s1, $s1, 1
+	eret`
+	em := NewEmulator()
+	em.WriteReg("Compare", 50)
+	if err := em.LoadAndRun(assembleString(t, src)); err != nil {
+		t.Fatal(err)
+	}
+	// the handler starts right after the third interrupt at Count 150
+	if v, _ := em.ReadReg("s3"); v != 151 {
+		t.Errorf("expect Count = 151 in the handler, got %d", v)
+	}
+	if v, _ := em.ReadReg("Cause"); ExcCode(v>>2&0x1F) != EXC_INT || v&(causeTI|causeIPHard) != 0 {
+		t.Errorf("expect an acknowledged timer interrupt, Cause = %#x", v)
+	}
+}
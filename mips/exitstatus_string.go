@@ -4,9 +4,9 @@ package mips
 
 import "fmt"
 
-const _ExitStatus_name = "EXIT_NORMALEXIT_INTEXIT_EOFEXIT_ERROR"
+const _ExitStatus_name = "EXIT_NORMALEXIT_INTEXIT_EOFEXIT_TIMEOUTEXIT_ERROREXIT_LIMIT"
 
-var _ExitStatus_index = [...]uint8{0, 11, 19, 27, 37}
+var _ExitStatus_index = [...]uint8{0, 11, 19, 27, 39, 49, 59}
 
 func (i ExitStatus) String() string {
 	if i < 0 || i+1 >= ExitStatus(len(_ExitStatus_index)) {
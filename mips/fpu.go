@@ -0,0 +1,336 @@
+package mips
+
+import (
+	"math"
+	"strconv"
+)
+
+// fmt field of coprocessor 1 instructions, in the rs position
+const (
+	cop1MF = 0x00 // mfc1
+	cop1CF = 0x02 // cfc1
+	cop1MT = 0x04 // mtc1
+	cop1CT = 0x06 // ctc1
+	cop1BC = 0x08 // bc1f, bc1t
+	cop1S  = 0x10 // single
+	cop1D  = 0x11 // double
+	cop1W  = 0x14 // word
+)
+
+// floating-point control registers and their bits
+const (
+	fcrFIR  = 0
+	fcrFCCR = 25
+	fcrFCSR = 31
+
+	fcsrRM  = 0x3     // rounding mode
+	fcsrCC0 = 1 << 23 // condition code 0
+	fcsrCC  = 0xFE<<24 | fcsrCC0
+	// FIR reports single, double and word formats
+	firValue = 1<<16 | 1<<17 | 1<<20
+)
+
+// rounding modes of FCSR
+const (
+	roundNearest = iota
+	roundZero
+	roundUp
+	roundDown
+)
+
+var fpFormats = []struct {
+	suffix string
+	fmt    int
+}{
+	{"s", cop1S},
+	{"d", cop1D},
+}
+
+// fpConditions are the conditions of c.cond.fmt, indexed by the low
+// 4 bits of funct: bit 0 is unordered, bit 1 equal and bit 2 less than.
+// The upper half also signals on NaN, which is not trapped here.
+var fpConditions = []string{
+	"f", "un", "eq", "ueq", "olt", "ult", "ole", "ule",
+	"sf", "ngle", "seq", "ngl", "lt", "nge", "le", "ngt",
+}
+
+// addFPUInstructions adds the instructions of coprocessor 1 to
+// instructionTable and funcTable. Names are formed from the operation
+// and formats, e.g. "add.s" and "cvt.d.w".
+func addFPUInstructions() {
+	add := func(name string, inst instInfo, f instFunc) {
+		instructionTable[name] = inst
+		funcTable[name] = f
+	}
+	cop1 := func(fmt, funct int, syntax []argType, formats []fmtType) instInfo {
+		return instInfo{
+			typ:     "R",
+			syntax:  syntax,
+			formats: formats,
+			opcode:  0x11,
+			rs:      fmt,
+			funct:   funct,
+		}
+	}
+	binary := []argType{argFReg, argFReg, argFReg}
+	unary := []argType{argFReg, argFReg}
+	binaryFmt := []fmtType{fmtFd, fmtRegD, fmtRegT}
+	unaryFmt := []fmtType{fmtFd, fmtRegD}
+
+	for _, ff := range fpFormats {
+		f, suffix := ff.fmt, "."+ff.suffix
+		for funct, op := range []func(a, b float64) float64{
+			func(a, b float64) float64 { return a + b },
+			func(a, b float64) float64 { return a - b },
+			func(a, b float64) float64 { return a * b },
+			func(a, b float64) float64 { return a / b },
+		} {
+			op := op
+			name := []string{"add", "sub", "mul", "div"}[funct] + suffix
+			add(name, cop1(f, funct, binary, binaryFmt), func(m *Machine, args ...int) {
+				m.writeFP(f, args[0], op(m.readFP(f, args[1]), m.readFP(f, args[2])))
+			})
+		}
+		add("sqrt"+suffix, cop1(f, 0x4, unary, unaryFmt), func(m *Machine, args ...int) {
+			m.writeFP(f, args[0], math.Sqrt(m.readFP(f, args[1])))
+		})
+		add("abs"+suffix, cop1(f, 0x5, unary, unaryFmt), func(m *Machine, args ...int) {
+			m.writeFP(f, args[0], math.Abs(m.readFP(f, args[1])))
+		})
+		add("mov"+suffix, cop1(f, 0x6, unary, unaryFmt), func(m *Machine, args ...int) {
+			m.checkFPReg(f, args[0])
+			m.checkFPReg(f, args[1])
+			m.r.fpr[args[0]] = m.r.fpr[args[1]]
+			if f == cop1D {
+				m.r.fpr[args[0]+1] = m.r.fpr[args[1]+1]
+			}
+		})
+		add("neg"+suffix, cop1(f, 0x7, unary, unaryFmt), func(m *Machine, args ...int) {
+			m.writeFP(f, args[0], -m.readFP(f, args[1]))
+		})
+		// round.w, trunc.w, ceil.w and floor.w, in the order of their
+		// funct from 0xC and of the rounding modes
+		for mode, name := range []string{"round", "trunc", "ceil", "floor"} {
+			mode := mode
+			add(name+".w"+suffix, cop1(f, 0xC+mode, unary, unaryFmt), func(m *Machine, args ...int) {
+				m.r.fpr[args[0]] = fpToWord(m.readFP(f, args[1]), mode)
+			})
+		}
+		for cond, name := range fpConditions {
+			cond := cond
+			add("c."+name+suffix, cop1(f, 0x30|cond, unary, []fmtType{fmtRegD, fmtRegT}),
+				func(m *Machine, args ...int) {
+					a, b := m.readFP(f, args[0]), m.readFP(f, args[1])
+					unordered := math.IsNaN(a) || math.IsNaN(b)
+					c := cond&4 != 0 && a < b ||
+						cond&2 != 0 && a == b ||
+						cond&1 != 0 && unordered
+					m.setFPCond(c)
+				})
+		}
+	}
+	// conversions, named cvt.to.from
+	for _, c := range []struct {
+		to, from int
+		name     string
+	}{
+		{cop1S, cop1D, "cvt.s.d"},
+		{cop1S, cop1W, "cvt.s.w"},
+		{cop1D, cop1S, "cvt.d.s"},
+		{cop1D, cop1W, "cvt.d.w"},
+	} {
+		c := c
+		funct := 0x20
+		if c.to == cop1D {
+			funct = 0x21
+		}
+		add(c.name, cop1(c.from, funct, unary, unaryFmt), func(m *Machine, args ...int) {
+			m.writeFP(c.to, args[0], m.readFP(c.from, args[1]))
+		})
+	}
+	for _, ff := range fpFormats {
+		f := ff.fmt
+		add("cvt.w."+ff.suffix, cop1(f, 0x24, unary, unaryFmt), func(m *Machine, args ...int) {
+			m.r.fpr[args[0]] = fpToWord(m.readFP(f, args[1]), int(m.r.fcsr&fcsrRM))
+		})
+	}
+
+	// moves between processors
+	move := []argType{argReg, argFReg}
+	moveFmt := []fmtType{fmtRegT, fmtRegD}
+	add("mfc1", cop1(cop1MF, 0, move, moveFmt), func(m *Machine, args ...int) {
+		m.r.write(args[0], m.r.fpr[args[1]])
+	})
+	add("mtc1", cop1(cop1MT, 0, move, moveFmt), func(m *Machine, args ...int) {
+		m.r.fpr[args[1]] = m.r.read(args[0])
+	})
+	control := []argType{argReg, argCReg}
+	add("cfc1", cop1(cop1CF, 0, control, moveFmt), func(m *Machine, args ...int) {
+		m.r.write(args[0], m.readFCR(args[1]))
+	})
+	add("ctc1", cop1(cop1CT, 0, control, moveFmt), func(m *Machine, args ...int) {
+		m.writeFCR(args[1], m.r.read(args[0]))
+	})
+
+	// loads and stores
+	mem := []argType{argFReg, argAddr}
+	memFmt := []fmtType{fmtRegT, fmtRegS, fmtImmediate}
+	memInst := func(opcode int) instInfo {
+		return instInfo{typ: "I", syntax: mem, formats: memFmt, opcode: opcode}
+	}
+	add("lwc1", memInst(0x31), func(m *Machine, args ...int) {
+		m.r.fpr[args[0]] = m.loadWord(m.r.read(args[1]) + uint32(args[2]))
+	})
+	add("swc1", memInst(0x39), func(m *Machine, args ...int) {
+		m.storeWord(m.r.read(args[1])+uint32(args[2]), m.r.fpr[args[0]])
+	})
+	add("ldc1", memInst(0x35), func(m *Machine, args ...int) {
+		addr := m.r.read(args[1]) + uint32(args[2])
+		m.checkFPReg(cop1D, args[0])
+		if addr&7 != 0 {
+			m.raise(EXC_ADEL, addr)
+		}
+		lo, hi := m.doubleWords(addr)
+		m.r.fpr[args[0]] = m.loadWord(lo)
+		m.r.fpr[args[0]+1] = m.loadWord(hi)
+	})
+	add("sdc1", memInst(0x3D), func(m *Machine, args ...int) {
+		addr := m.r.read(args[1]) + uint32(args[2])
+		m.checkFPReg(cop1D, args[0])
+		if addr&7 != 0 {
+			m.raise(EXC_ADES, addr)
+		}
+		lo, hi := m.doubleWords(addr)
+		m.storeWord(lo, m.r.fpr[args[0]])
+		m.storeWord(hi, m.r.fpr[args[0]+1])
+	})
+
+	// branches on condition code 0
+	bc := func(tf int) instInfo {
+		return instInfo{
+			typ:     "I",
+			syntax:  []argType{argInteger | argLabel},
+			formats: []fmtType{fmtImmediate},
+			opcode:  0x11,
+			rs:      cop1BC,
+			rt:      tf,
+			branch:  true,
+		}
+	}
+	add("bc1f", bc(0), func(m *Machine, args ...int) {
+		if !m.fpCond() {
+			m.branch(m.r.PC + 4 + uint32(args[0]<<2))
+		}
+	})
+	add("bc1t", bc(1), func(m *Machine, args ...int) {
+		if m.fpCond() {
+			m.branch(m.r.PC + 4 + uint32(args[0]<<2))
+		}
+	})
+
+	for i := 0; i < 32; i++ {
+		fpRegisterTable["f"+strconv.Itoa(i)] = i
+	}
+}
+
+// checkFPReg raises a reserved instruction exception if id can not hold
+// a value of format f: doubles take an even-odd pair of registers.
+func (m *Machine) checkFPReg(f, id int) {
+	if f == cop1D && id&1 != 0 {
+		m.raise(EXC_RI, 0)
+	}
+}
+
+// doubleWords returns the addresses of the low and high words of the
+// double at addr
+func (m *Machine) doubleWords(addr uint32) (lo, hi uint32) {
+	if m.m.bigEndian() {
+		return addr + 4, addr
+	}
+	return addr, addr + 4
+}
+
+// readFP returns floating-point register id interpreted in format f
+func (m *Machine) readFP(f, id int) float64 {
+	m.checkFPReg(f, id)
+	switch f {
+	case cop1S:
+		return float64(math.Float32frombits(m.r.fpr[id]))
+	case cop1D:
+		return math.Float64frombits(uint64(m.r.fpr[id+1])<<32 | uint64(m.r.fpr[id]))
+	default:
+		return float64(int32(m.r.fpr[id]))
+	}
+}
+
+// writeFP rounds v to format f and writes it to register id. The low
+// word of a double goes to the even register.
+func (m *Machine) writeFP(f, id int, v float64) {
+	m.checkFPReg(f, id)
+	switch f {
+	case cop1S:
+		m.r.fpr[id] = math.Float32bits(float32(v))
+	case cop1D:
+		b := math.Float64bits(v)
+		m.r.fpr[id], m.r.fpr[id+1] = uint32(b), uint32(b>>32)
+	default:
+		m.r.fpr[id] = fpToWord(v, int(m.r.fcsr&fcsrRM))
+	}
+}
+
+// fpToWord rounds v to a 32-bit integer in the given rounding mode. NaN
+// and values out of range give the default result 2^31-1.
+func fpToWord(v float64, mode int) uint32 {
+	switch mode {
+	case roundNearest:
+		v = math.RoundToEven(v)
+	case roundZero:
+		v = math.Trunc(v)
+	case roundUp:
+		v = math.Ceil(v)
+	case roundDown:
+		v = math.Floor(v)
+	}
+	if math.IsNaN(v) || v < math.MinInt32 || v > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return uint32(int32(v))
+}
+
+// fpCond returns condition code 0 of FCSR
+func (m *Machine) fpCond() bool {
+	return m.r.fcsr&fcsrCC0 != 0
+}
+
+func (m *Machine) setFPCond(c bool) {
+	if c {
+		m.r.fcsr |= fcsrCC0
+	} else {
+		m.r.fcsr &^= fcsrCC0
+	}
+}
+
+// readFCR reads a floating-point control register
+func (m *Machine) readFCR(id int) uint32 {
+	switch id {
+	case fcrFIR:
+		return firValue
+	case fcrFCCR:
+		// condition codes 7..1 and 0, packed
+		return m.r.fcsr>>24&0xFE | m.r.fcsr>>23&1
+	case fcrFCSR:
+		return m.r.fcsr
+	}
+	return 0
+}
+
+// writeFCR writes a floating-point control register, ignoring those
+// that are read-only or not implemented
+func (m *Machine) writeFCR(id int, value uint32) {
+	switch id {
+	case fcrFCCR:
+		m.r.fcsr = m.r.fcsr&^fcsrCC | value&0xFE<<24 | value&1<<23
+	case fcrFCSR:
+		m.r.fcsr = value
+	}
+}
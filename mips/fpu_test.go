@@ -0,0 +1,148 @@
+package mips
+
+import (
+	"math"
+	"testing"
+)
+
+func f32(f float32) uint32 { return math.Float32bits(f) }
+
+// f64 returns the low and high words of f
+func f64(f float64) (uint32, uint32) {
+	b := math.Float64bits(f)
+	return uint32(b), uint32(b >> 32)
+}
+
+func TestFPUConformance(t *testing.T) {
+	lo, hi := f64(1.25)
+	lo2, hi2 := f64(0.5)
+	sum, sumHi := f64(1.75)
+	root, rootHi := f64(math.Sqrt2)
+	two, twoHi := f64(2)
+	tests := []struct {
+		src        string
+		init, want state
+	}{
+		// arithmetic
+		{"add.s $f0, $f2, $f4", state{"f2": f32(1.5), "f4": f32(2.25)},
+			state{"f0": f32(3.75)}},
+		{"sub.s $f0, $f2, $f4", state{"f2": f32(1.5), "f4": f32(2.25)},
+			state{"f0": f32(-0.75)}},
+		{"mul.s $f1, $f2, $f3", state{"f2": f32(1.5), "f3": f32(-4)},
+			state{"f1": f32(-6)}},
+		{"div.s $f0, $f2, $f4", state{"f2": f32(1), "f4": 0},
+			state{"f0": f32(float32(math.Inf(1)))}},
+		{"add.d $f0, $f2, $f4", state{"f2": lo, "f3": hi, "f4": lo2, "f5": hi2},
+			state{"f0": sum, "f1": sumHi}},
+		{"sqrt.d $f0, $f2", state{"f2": two, "f3": twoHi},
+			state{"f0": root, "f1": rootHi}},
+		{"abs.s $f0, $f2", state{"f2": f32(-3)}, state{"f0": f32(3)}},
+		{"neg.d $f0, $f2", state{"f2": lo, "f3": hi},
+			state{"f0": lo, "f1": hi | 0x80000000}},
+		{"mov.d $f4, $f2", state{"f2": lo, "f3": hi}, state{"f4": lo, "f5": hi}},
+		// conversion
+		{"cvt.s.w $f0, $f2", state{"f2": 0xFFFFFFFE}, state{"f0": f32(-2)}},
+		{"cvt.d.s $f0, $f2", state{"f2": f32(1.25)}, state{"f0": lo, "f1": hi}},
+		{"cvt.s.d $f0, $f2", state{"f2": lo, "f3": hi}, state{"f0": f32(1.25)}},
+		{"cvt.w.s $f0, $f2", state{"f2": f32(2.5)}, state{"f0": 2}},
+		{"cvt.w.s $f0, $f2", state{"f2": f32(2.5), "FCSR": roundUp},
+			state{"f0": 3}},
+		{"cvt.w.s $f0, $f2", state{"f2": f32(float32(math.NaN()))},
+			state{"f0": 0x7FFFFFFF}},
+		{"trunc.w.s $f0, $f2", state{"f2": f32(-2.7)}, state{"f0": 0xFFFFFFFE}},
+		{"round.w.d $f0, $f2", state{"f2": lo, "f3": hi}, state{"f0": 1}},
+		{"ceil.w.s $f0, $f2", state{"f2": f32(-2.7)}, state{"f0": 0xFFFFFFFE}},
+		{"floor.w.s $f0, $f2", state{"f2": f32(-2.7)}, state{"f0": 0xFFFFFFFD}},
+		// comparison and branch
+		{"c.lt.s $f2, $f4", state{"f2": f32(1), "f4": f32(2)},
+			state{"FCSR": fcsrCC0}},
+		{"c.le.d $f2, $f4", state{"f2": lo, "f3": hi, "f4": lo2, "f5": hi2,
+			"FCSR": fcsrCC0}, state{"FCSR": 0}},
+		{"c.eq.s $f2, $f4", state{"f2": f32(float32(math.NaN()))},
+			state{"FCSR": 0}},
+		{"c.ueq.s $f2, $f4", state{"f2": f32(float32(math.NaN()))},
+			state{"FCSR": fcsrCC0}},
+		{"bc1t 3", state{"PC": 0x100, "FCSR": fcsrCC0}, state{"PC": 0x110}},
+		{"bc1t 3", state{"PC": 0x100}, state{"PC": 0x104}},
+		{"bc1f 3", state{"PC": 0x100}, state{"PC": 0x110}},
+		// moves
+		{"mfc1 $t0, $f3", state{"f3": 0xDEADBEEF}, state{"t0": 0xDEADBEEF}},
+		{"mtc1 $t0, $f3", state{"t0": 0xDEADBEEF}, state{"f3": 0xDEADBEEF}},
+		{"cfc1 $t0, $25", state{"FCSR": 1<<25 | fcsrCC0}, state{"t0": 3}},
+		{"ctc1 $t0, $25", state{"t0": 0x81}, state{"FCSR": 1<<31 | fcsrCC0}},
+		{"cfc1 $t0, $0", nil, state{"t0": firValue}},
+		// load and store
+		{"lwc1 $f1, 4($t0)", state{"t0": DATA_ADDRESS, "@4000004": f32(1.5)},
+			state{"f1": f32(1.5)}},
+		{"swc1 $f1, 0($t0)", state{"t0": DATA_ADDRESS, "f1": f32(1.5)},
+			state{"@4000000": f32(1.5)}},
+		{"ldc1 $f2, 8($t0)", state{"t0": DATA_ADDRESS, "@4000008": lo, "@400000c": hi},
+			state{"f2": lo, "f3": hi}},
+		{"sdc1 $f2, 0($t0)", state{"t0": DATA_ADDRESS, "f2": lo, "f3": hi},
+			state{"@4000000": lo, "@4000004": hi}},
+	}
+	for _, tt := range tests {
+		conform(t, tt.src, tt.init, tt.want)
+	}
+}
+
+func TestFPUProgram(t *testing.T) {
+	// Sum an array of doubles, and compare it with a float constant
+	em := NewEmulator()
+	if err := em.LoadAndRun(assembleString(t, `.data
+xs:	.double 0.5, 1.25, -3
+limit:	.float 1e3
+	.align 3
+sum:	.double 0
+.text
+main:
+	la $t0, xs
+	li $t1, 3
+	mtc1 $zero, $f0
+	mtc1 $zero, $f1
+loop:
+	l.d $f2, 0($t0)
+	add.d $f0, $f0, $f2
+	addi $t0, $t0, 8
+	addi $t1, $t1, -1
+	bgtz $t1, loop
+	la $t0, sum
+	s.d $f0, 0($t0)
+	cvt.s.d $f4, $f0
+	la $t0, limit
+	l.s $f6, 0($t0)
+	li.s $f8, -1.25
+	c.lt.s $f4, $f6
+	bc1f done
+	li.d $f10, 2.5
+	li $s0, 1
+done:
+	li $v0, 10
+	syscall`)); err != nil {
+		t.Fatal(err)
+	}
+	lo, _ := em.ReadMemory(DATA_ADDRESS + 32)
+	hi, _ := em.ReadMemory(DATA_ADDRESS + 36)
+	if got := math.Float64frombits(uint64(hi)<<32 | uint64(lo)); got != -1.25 {
+		t.Errorf("expect sum = -1.25, got %v", got)
+	}
+	if v, _ := em.ReadReg("f8"); math.Float32frombits(v) != -1.25 {
+		t.Errorf("expect $f8 = -1.25, got %v", math.Float32frombits(v))
+	}
+	if v, _ := em.ReadReg("s0"); v != 1 {
+		t.Error("expect bc1f not to be taken")
+	}
+	lo, _ = em.ReadReg("f10")
+	hi, _ = em.ReadReg("f11")
+	if got := math.Float64frombits(uint64(hi)<<32 | uint64(lo)); got != 2.5 {
+		t.Errorf("expect $f10 = 2.5, got %v", got)
+	}
+}
+
+func TestFPUOddDouble(t *testing.T) {
+	em := NewEmulator()
+	err := em.LoadAndRun(assembleString(t, "main:\n\tadd.d $f0, $f1, $f2"))
+	if v, _ := em.ReadReg("Cause"); err == nil || ExcCode(v>>2&0x1F) != EXC_RI {
+		t.Errorf("expect a reserved instruction exception, got %v", err)
+	}
+}
@@ -1,89 +1,166 @@
 package mips
 
-import (
-	"bytes"
-	"fmt"
-)
+import "math/bits"
 
 type instFunc func(*Machine, ...int)
 
 var (
 	funcTable = map[string]instFunc{
 		"add": func(m *Machine, args ...int) {
-			m.r.write(args[0], m.r.read(args[1])+m.r.read(args[2]))
+			rs, rt := m.r.read(args[1]), m.r.read(args[2])
+			sum := rs + rt
+			if (rs^sum)&(rt^sum)&0x80000000 != 0 {
+				m.raise(EXC_OV, 0)
+			}
+			m.r.write(args[0], sum)
 		},
 		"addu": func(m *Machine, args ...int) {
 			m.r.write(args[0], m.r.read(args[1])+m.r.read(args[2]))
 		},
 		"sub": func(m *Machine, args ...int) {
-			m.r.write(args[0], m.r.read(args[1])-m.r.read(args[2]))
+			rs, rt := m.r.read(args[1]), m.r.read(args[2])
+			diff := rs - rt
+			if (rs^rt)&(rs^diff)&0x80000000 != 0 {
+				m.raise(EXC_OV, 0)
+			}
+			m.r.write(args[0], diff)
 		},
 		"subu": func(m *Machine, args ...int) {
 			m.r.write(args[0], m.r.read(args[1])-m.r.read(args[2]))
 		},
 		"addi": func(m *Machine, args ...int) {
-			m.r.write(args[0], m.r.read(args[1])+args[2])
+			rs, imm := m.r.read(args[1]), uint32(args[2])
+			sum := rs + imm
+			if (rs^sum)&(imm^sum)&0x80000000 != 0 {
+				m.raise(EXC_OV, 0)
+			}
+			m.r.write(args[0], sum)
 		},
 		"addiu": func(m *Machine, args ...int) {
-			m.r.write(args[0], m.r.read(args[1])+int(uint(args[2])))
+			m.r.write(args[0], m.r.read(args[1])+uint32(args[2]))
 		},
 		"mult": func(m *Machine, args ...int) {
-			mult := m.r.read(args[0]) * m.r.read(args[1])
-			m.r.HI = (mult >> 16) & 0xFFFF
-			m.r.LO = mult & 0xFFFF
+			mult := int64(int32(m.r.read(args[0]))) * int64(int32(m.r.read(args[1])))
+			m.r.HI = uint32(mult >> 32)
+			m.r.LO = uint32(mult)
 		},
 		"multu": func(m *Machine, args ...int) {
-			mult := uint(m.r.read(args[0])) * uint(m.r.read(args[1]))
-			m.r.HI = int((mult >> 16) & 0xFFFF)
-			m.r.LO = int(mult & 0xFFFF)
+			mult := uint64(m.r.read(args[0])) * uint64(m.r.read(args[1]))
+			m.r.HI = uint32(mult >> 32)
+			m.r.LO = uint32(mult)
 		},
 		"div": func(m *Machine, args ...int) {
-			m.r.HI = m.r.read(args[0]) % m.r.read(args[1])
-			m.r.LO = m.r.read(args[0]) / m.r.read(args[1])
+			rs, rt := int32(m.r.read(args[0])), int32(m.r.read(args[1]))
+			// The result of dividing by zero is unpredictable,
+			// leave HI and LO unchanged like SPIM.
+			if rt == 0 {
+				return
+			}
+			m.r.HI = uint32(rs % rt)
+			m.r.LO = uint32(rs / rt)
 		},
 		"divu": func(m *Machine, args ...int) {
-			m.r.HI = int(uint(m.r.read(args[0])) % uint(m.r.read(args[1])))
-			m.r.LO = int(uint(m.r.read(args[0])) / uint(m.r.read(args[1])))
+			rs, rt := m.r.read(args[0]), m.r.read(args[1])
+			if rt == 0 {
+				return
+			}
+			m.r.HI = rs % rt
+			m.r.LO = rs / rt
+		},
+		"mul": func(m *Machine, args ...int) {
+			// Unlike mult, HI and LO are not written
+			m.r.write(args[0], m.r.read(args[1])*m.r.read(args[2]))
+		},
+		"madd": func(m *Machine, args ...int) {
+			p := int64(int32(m.r.read(args[0]))) * int64(int32(m.r.read(args[1])))
+			m.r.writeHILO(m.r.readHILO() + uint64(p))
+		},
+		"maddu": func(m *Machine, args ...int) {
+			p := uint64(m.r.read(args[0])) * uint64(m.r.read(args[1]))
+			m.r.writeHILO(m.r.readHILO() + p)
+		},
+		"msub": func(m *Machine, args ...int) {
+			p := int64(int32(m.r.read(args[0]))) * int64(int32(m.r.read(args[1])))
+			m.r.writeHILO(m.r.readHILO() - uint64(p))
+		},
+		"msubu": func(m *Machine, args ...int) {
+			p := uint64(m.r.read(args[0])) * uint64(m.r.read(args[1]))
+			m.r.writeHILO(m.r.readHILO() - p)
 		},
 		"lw": func(m *Machine, args ...int) {
-			i, err := m.m.readWord(m.r.read(args[1]) + args[2])
-			checkInstErr(err)
-			m.r.write(args[0], i)
+			m.writeLoad(args[0], m.loadWord(m.r.read(args[1])+uint32(args[2])))
 		},
 		"lh": func(m *Machine, args ...int) {
-			i, err := m.m.readHalf(m.r.read(args[1]) + args[2])
-			checkInstErr(err)
-			m.r.write(args[0], i)
+			i := m.loadHalf(m.r.read(args[1]) + uint32(args[2]))
+			m.writeLoad(args[0], uint32(int16(i)))
 		},
 		"lhu": func(m *Machine, args ...int) {
-			i, err := m.m.readHalf(m.r.read(args[1]) + args[2])
-			checkInstErr(err)
-			m.r.write(args[0], int(uint(i)))
+			i := m.loadHalf(m.r.read(args[1]) + uint32(args[2]))
+			m.writeLoad(args[0], uint32(i))
 		},
 		"lb": func(m *Machine, args ...int) {
-			i, err := m.m.read(m.r.read(args[1]) + args[2])
-			checkInstErr(err)
-			m.r.write(args[0], int(i))
+			i := m.loadByte(m.r.read(args[1]) + uint32(args[2]))
+			m.writeLoad(args[0], uint32(int8(i)))
 		},
 		"lbu": func(m *Machine, args ...int) {
-			i, err := m.m.read(m.r.read(args[1]) + args[2])
-			checkInstErr(err)
-			m.r.write(args[0], int(uint(i)))
+			i := m.loadByte(m.r.read(args[1]) + uint32(args[2]))
+			m.writeLoad(args[0], uint32(i))
+		},
+		"lwl": func(m *Machine, args ...int) {
+			// Merge the bytes from addr up to the word boundary into the
+			// most-significant end of the register
+			addr := m.r.read(args[1]) + uint32(args[2])
+			w := m.loadWord(addr &^ 3)
+			sh := 8 * (3 - m.m.byteLane(addr))
+			m.writeLoad(args[0], w<<sh|m.loadMerge(args[0])&(0xFFFFFFFF>>(32-sh)))
+		},
+		"lwr": func(m *Machine, args ...int) {
+			// Merge the bytes from the word boundary up to addr into the
+			// least-significant end of the register
+			addr := m.r.read(args[1]) + uint32(args[2])
+			w := m.loadWord(addr &^ 3)
+			sh := 8 * m.m.byteLane(addr)
+			m.writeLoad(args[0], w>>sh|m.loadMerge(args[0])&^(0xFFFFFFFF>>sh))
+		},
+		"ll": func(m *Machine, args ...int) {
+			addr := m.r.read(args[1]) + uint32(args[2])
+			m.writeLoad(args[0], m.loadWord(addr))
+			m.llBit, m.llAddr = true, addr
 		},
 		"sw": func(m *Machine, args ...int) {
-			err := m.m.writeWord(m.r.read(args[1])+args[2], m.r.read(args[0]))
-			checkInstErr(err)
+			m.storeWord(m.r.read(args[1])+uint32(args[2]), m.r.read(args[0]))
 		},
 		"sh": func(m *Machine, args ...int) {
-			err := m.m.writeHalf(m.r.read(args[1])+args[2], m.r.read(args[0])&0xFFFF)
-			checkInstErr(err)
+			m.storeHalf(m.r.read(args[1])+uint32(args[2]), uint16(m.r.read(args[0])))
 		},
 		"sb": func(m *Machine, args ...int) {
-			err := m.m.write(m.r.read(args[1])+args[2], byte(m.r.read(args[0])&0xFF))
-			checkInstErr(err)
+			m.storeByte(m.r.read(args[1])+uint32(args[2]), byte(m.r.read(args[0])))
+		},
+		"swl": func(m *Machine, args ...int) {
+			addr := m.r.read(args[1]) + uint32(args[2])
+			w := m.mergeWord(addr)
+			sh := 8 * (3 - m.m.byteLane(addr))
+			m.storeWord(addr&^3, m.r.read(args[0])>>sh|w&^(0xFFFFFFFF>>sh))
+		},
+		"swr": func(m *Machine, args ...int) {
+			addr := m.r.read(args[1]) + uint32(args[2])
+			w := m.mergeWord(addr)
+			sh := 8 * m.m.byteLane(addr)
+			m.storeWord(addr&^3, m.r.read(args[0])<<sh|w&^(0xFFFFFFFF<<sh))
+		},
+		"sc": func(m *Machine, args ...int) {
+			// Store only if nothing broke the link since ll, and report
+			// success in the register
+			addr := m.r.read(args[1]) + uint32(args[2])
+			if !m.llBit || addr != m.llAddr {
+				m.r.write(args[0], 0)
+				return
+			}
+			m.storeWord(addr, m.r.read(args[0]))
+			m.r.write(args[0], 1)
 		},
 		"lui": func(m *Machine, args ...int) {
-			m.r.write(args[0], args[1]<<16)
+			m.r.write(args[0], uint32(args[1])<<16)
 		},
 		"mfhi": func(m *Machine, args ...int) {
 			m.r.write(args[0], m.r.HI)
@@ -91,133 +168,275 @@ var (
 		"mflo": func(m *Machine, args ...int) {
 			m.r.write(args[0], m.r.LO)
 		},
+		"mthi": func(m *Machine, args ...int) {
+			m.r.HI = m.r.read(args[0])
+		},
+		"mtlo": func(m *Machine, args ...int) {
+			m.r.LO = m.r.read(args[0])
+		},
 		"and": func(m *Machine, args ...int) {
 			m.r.write(args[0], m.r.read(args[1])&m.r.read(args[2]))
 		},
 		"andi": func(m *Machine, args ...int) {
-			m.r.write(args[0], m.r.read(args[1])&(args[2]&0x0000FFFF))
+			m.r.write(args[0], m.r.read(args[1])&(uint32(args[2])&0x0000FFFF))
 		},
 		"or": func(m *Machine, args ...int) {
 			m.r.write(args[0], m.r.read(args[1])|m.r.read(args[2]))
 		},
 		"ori": func(m *Machine, args ...int) {
-			m.r.write(args[0], m.r.read(args[1])|(args[2]&0x0000FFFF))
+			m.r.write(args[0], m.r.read(args[1])|(uint32(args[2])&0x0000FFFF))
+		},
+		"xori": func(m *Machine, args ...int) {
+			m.r.write(args[0], m.r.read(args[1])^(uint32(args[2])&0x0000FFFF))
 		},
 		"xor": func(m *Machine, args ...int) {
 			m.r.write(args[0], m.r.read(args[1])^m.r.read(args[2]))
 		},
 		"nor": func(m *Machine, args ...int) {
-			m.r.write(args[0], ^(m.r.read(args[1]) & m.r.read(args[2])))
+			m.r.write(args[0], ^(m.r.read(args[1]) | m.r.read(args[2])))
+		},
+		"clz": func(m *Machine, args ...int) {
+			m.r.write(args[0], uint32(bits.LeadingZeros32(m.r.read(args[1]))))
+		},
+		"clo": func(m *Machine, args ...int) {
+			m.r.write(args[0], uint32(bits.LeadingZeros32(^m.r.read(args[1]))))
+		},
+		"ext": func(m *Machine, args ...int) {
+			pos, size := uint(args[2]), uint(args[3])
+			m.r.write(args[0], m.r.read(args[1])>>pos&(0xFFFFFFFF>>(32-size)))
+		},
+		"ins": func(m *Machine, args ...int) {
+			pos, size := uint(args[2]), uint(args[3])
+			mask := uint32(0xFFFFFFFF) >> (32 - size) << pos
+			m.r.write(args[0], m.r.read(args[0])&^mask|m.r.read(args[1])<<pos&mask)
+		},
+		"seb": func(m *Machine, args ...int) {
+			m.r.write(args[0], uint32(int8(m.r.read(args[1]))))
+		},
+		"seh": func(m *Machine, args ...int) {
+			m.r.write(args[0], uint32(int16(m.r.read(args[1]))))
+		},
+		"wsbh": func(m *Machine, args ...int) {
+			// Swap the bytes within each halfword
+			x := m.r.read(args[1])
+			m.r.write(args[0], x<<8&0xFF00FF00|x>>8&0x00FF00FF)
+		},
+		"rdhwr": func(m *Machine, args ...int) {
+			switch args[1] {
+			case 0: // CPUNum, the core ID
+				m.r.write(args[0], uint32(m.id))
+			case 2: // CC, the cycle counter
+				m.r.write(args[0], m.c0[c0Count])
+			case 3: // CCRes, cycles per count
+				m.r.write(args[0], 1)
+			default:
+				m.raise(EXC_RI, 0)
+			}
 		},
 		"slt": func(m *Machine, args ...int) {
-			if m.r.read(args[1]) < m.r.read(args[2]) {
+			if int32(m.r.read(args[1])) < int32(m.r.read(args[2])) {
 				m.r.write(args[0], 1)
 			} else {
 				m.r.write(args[0], 0)
 			}
 		},
 		"slti": func(m *Machine, args ...int) {
-			if m.r.read(args[1]) < args[2] {
+			if int32(m.r.read(args[1])) < int32(args[2]) {
+				m.r.write(args[0], 1)
+			} else {
+				m.r.write(args[0], 0)
+			}
+		},
+		"sltu": func(m *Machine, args ...int) {
+			if m.r.read(args[1]) < m.r.read(args[2]) {
 				m.r.write(args[0], 1)
 			} else {
 				m.r.write(args[0], 0)
 			}
 		},
+		"sltiu": func(m *Machine, args ...int) {
+			// The immediate is sign-extended, then compared unsigned
+			if m.r.read(args[1]) < uint32(args[2]) {
+				m.r.write(args[0], 1)
+			} else {
+				m.r.write(args[0], 0)
+			}
+		},
+		"movz": func(m *Machine, args ...int) {
+			if m.r.read(args[2]) == 0 {
+				m.r.write(args[0], m.r.read(args[1]))
+			}
+		},
+		"movn": func(m *Machine, args ...int) {
+			if m.r.read(args[2]) != 0 {
+				m.r.write(args[0], m.r.read(args[1]))
+			}
+		},
 		"sll": func(m *Machine, args ...int) {
-			m.r.write(args[0], m.r.read(args[1])<<uint(args[2]))
+			m.r.write(args[0], m.r.read(args[1])<<uint(args[2]&0x1F))
 		},
 		"srl": func(m *Machine, args ...int) {
-			m.r.write(args[0], int(uint(m.r.read(args[1]))>>uint(args[2])))
+			m.r.write(args[0], m.r.read(args[1])>>uint(args[2]&0x1F))
 		},
 		"sra": func(m *Machine, args ...int) {
-			m.r.write(args[0], m.r.read(args[1])>>uint(args[2]))
+			m.r.write(args[0], uint32(int32(m.r.read(args[1]))>>uint(args[2]&0x1F)))
 		},
 		"sllv": func(m *Machine, args ...int) {
-			m.r.write(args[0], m.r.read(args[1])<<uint(m.r.read(args[2])))
+			m.r.write(args[0], m.r.read(args[1])<<(m.r.read(args[2])&0x1F))
 		},
 		"srlv": func(m *Machine, args ...int) {
-			m.r.write(args[0], int(uint(m.r.read(args[1]))>>uint(m.r.read(args[2]))))
+			m.r.write(args[0], m.r.read(args[1])>>(m.r.read(args[2])&0x1F))
 		},
 		"srav": func(m *Machine, args ...int) {
-			m.r.write(args[0], m.r.read(args[1])>>uint(m.r.read(args[2])))
+			m.r.write(args[0], uint32(int32(m.r.read(args[1]))>>(m.r.read(args[2])&0x1F)))
+		},
+		"rotr": func(m *Machine, args ...int) {
+			m.r.write(args[0], bits.RotateLeft32(m.r.read(args[1]), -(args[2]&0x1F)))
+		},
+		"rotrv": func(m *Machine, args ...int) {
+			m.r.write(args[0], bits.RotateLeft32(m.r.read(args[1]), -int(m.r.read(args[2])&0x1F)))
 		},
 		"beq": func(m *Machine, args ...int) {
 			if m.r.read(args[0]) == m.r.read(args[1]) {
-				m.r.PC = m.r.PC + 4 + args[2]<<2
-			} else {
-				m.r.PC = m.r.PC + 4
+				m.branch(m.r.PC + 4 + uint32(args[2]<<2))
 			}
 		},
 		"bne": func(m *Machine, args ...int) {
 			if m.r.read(args[0]) != m.r.read(args[1]) {
-				m.r.PC = m.r.PC + 4 + args[2]<<2
-			} else {
-				m.r.PC = m.r.PC + 4
+				m.branch(m.r.PC + 4 + uint32(args[2]<<2))
+			}
+		},
+		"blez": func(m *Machine, args ...int) {
+			if int32(m.r.read(args[0])) <= 0 {
+				m.branch(m.r.PC + 4 + uint32(args[1]<<2))
+			}
+		},
+		"bgtz": func(m *Machine, args ...int) {
+			if int32(m.r.read(args[0])) > 0 {
+				m.branch(m.r.PC + 4 + uint32(args[1]<<2))
+			}
+		},
+		"bltz": func(m *Machine, args ...int) {
+			if int32(m.r.read(args[0])) < 0 {
+				m.branch(m.r.PC + 4 + uint32(args[1]<<2))
+			}
+		},
+		"bgez": func(m *Machine, args ...int) {
+			if int32(m.r.read(args[0])) >= 0 {
+				m.branch(m.r.PC + 4 + uint32(args[1]<<2))
+			}
+		},
+		"bltzal": func(m *Machine, args ...int) {
+			// The link register is written even if the branch is not taken
+			v := int32(m.r.read(args[0]))
+			m.r.write(31, m.link())
+			if v < 0 {
+				m.branch(m.r.PC + 4 + uint32(args[1]<<2))
+			}
+		},
+		"bgezal": func(m *Machine, args ...int) {
+			v := int32(m.r.read(args[0]))
+			m.r.write(31, m.link())
+			if v >= 0 {
+				m.branch(m.r.PC + 4 + uint32(args[1]<<2))
 			}
 		},
 		"j": func(m *Machine, args ...int) {
-			m.r.PC = ((m.r.PC + 4) & 0xF0000000) | ((args[0] << 2) & 0x0FFFFFFF)
+			m.branch(((m.r.PC + 4) & 0xF0000000) | (uint32(args[0]<<2) & 0x0FFFFFFF))
 		},
 		"jr": func(m *Machine, args ...int) {
-			m.r.PC = m.r.read(args[0])
+			m.branch(m.r.read(args[0]))
 		},
 		"jal": func(m *Machine, args ...int) {
-			m.r.write(31, m.r.PC+4)
-			m.r.PC = ((m.r.PC + 4) & 0xF0000000) | ((args[0] << 2) & 0x0FFFFFFF)
+			m.r.write(31, m.link())
+			m.branch(((m.r.PC + 4) & 0xF0000000) | (uint32(args[0]<<2) & 0x0FFFFFFF))
+		},
+		"jalr": func(m *Machine, args ...int) {
+			target := m.r.read(args[1])
+			m.r.write(args[0], m.link())
+			m.branch(target)
 		},
 		"syscall": systemCall,
+		"break": func(m *Machine, args ...int) {
+			m.raise(EXC_BP, 0)
+		},
+		"tge": func(m *Machine, args ...int) {
+			if int32(m.r.read(args[0])) >= int32(m.r.read(args[1])) {
+				m.trap(args[2])
+			}
+		},
+		"tgeu": func(m *Machine, args ...int) {
+			if m.r.read(args[0]) >= m.r.read(args[1]) {
+				m.trap(args[2])
+			}
+		},
+		"tlt": func(m *Machine, args ...int) {
+			if int32(m.r.read(args[0])) < int32(m.r.read(args[1])) {
+				m.trap(args[2])
+			}
+		},
+		"tltu": func(m *Machine, args ...int) {
+			if m.r.read(args[0]) < m.r.read(args[1]) {
+				m.trap(args[2])
+			}
+		},
+		"teq": func(m *Machine, args ...int) {
+			if m.r.read(args[0]) == m.r.read(args[1]) {
+				m.trap(args[2])
+			}
+		},
+		"tne": func(m *Machine, args ...int) {
+			if m.r.read(args[0]) != m.r.read(args[1]) {
+				m.trap(args[2])
+			}
+		},
+		"tgei": func(m *Machine, args ...int) {
+			if int32(m.r.read(args[0])) >= int32(args[1]) {
+				m.trap(0)
+			}
+		},
+		"tgeiu": func(m *Machine, args ...int) {
+			if m.r.read(args[0]) >= uint32(args[1]) {
+				m.trap(0)
+			}
+		},
+		"tlti": func(m *Machine, args ...int) {
+			if int32(m.r.read(args[0])) < int32(args[1]) {
+				m.trap(0)
+			}
+		},
+		"tltiu": func(m *Machine, args ...int) {
+			if m.r.read(args[0]) < uint32(args[1]) {
+				m.trap(0)
+			}
+		},
+		"teqi": func(m *Machine, args ...int) {
+			if m.r.read(args[0]) == uint32(args[1]) {
+				m.trap(0)
+			}
+		},
+		"tnei": func(m *Machine, args ...int) {
+			if m.r.read(args[0]) != uint32(args[1]) {
+				m.trap(0)
+			}
+		},
+		"mfc0": func(m *Machine, args ...int) {
+			m.privileged()
+			m.r.write(args[0], m.c0[args[1]])
+		},
+		"mtc0": func(m *Machine, args ...int) {
+			m.privileged()
+			m.writeC0(args[1], m.r.read(args[0]))
+		},
+		"eret": func(m *Machine, args ...int) {
+			m.privileged()
+			m.c0[c0Status] &^= statusEXL
+			m.llBit = false
+			m.jumpNow(m.c0[c0EPC])
+		},
 	}
 )
 
-func systemCall(m *Machine, args ...int) {
-	a0 := registerTable["a0"]
-	a1 := registerTable["a1"]
-	// a2 := registerTable["a2"]
-	v0 := registerTable["v0"]
-	switch m.r.read(v0) {
-	case 1: // print integer
-		fmt.Printf("%d", m.r.read(a0))
-	case 4: // print null-terminate string
-		buf := new(bytes.Buffer)
-		addr := m.r.read(a0)
-		b, err := m.m.read(addr)
-		for ; err == nil && b != 0; b, err = m.m.read(addr) {
-			err := buf.WriteByte(b)
-			checkInstErr(err)
-			addr++
-		}
-		checkInstErr(err)
-		fmt.Printf("%s", buf.String())
-	case 5: // read integer
-		var i int
-		_, err := fmt.Scanf("%d", &i)
-		checkInstErr(err)
-		m.r.write(v0, i)
-	case 8:
-		var s string
-		_, err := fmt.Scanf("%s", &s)
-		checkInstErr(err)
-		addr := m.r.read(a0)
-		max := m.r.read(a1)
-		if max < len(s) {
-			s = s[:max]
-		}
-		err = m.m.writeBytes(addr, []byte(s))
-		checkInstErr(err)
-	case 10:
-		m.exit = true
-	case 11:
-		ch := m.r.read(a0)
-		fmt.Printf("%c", ch)
-	case 12:
-		var ch rune
-		_, err := fmt.Scanf("%c\n", &ch)
-		checkInstErr(err)
-		m.r.write(v0, int(ch))
-	default:
-	}
-}
-
 func checkInstErr(err error) {
 	if err != nil {
 		panic(err)
@@ -0,0 +1,313 @@
+package mips
+
+import (
+	"encoding/binary"
+	"strconv"
+	"testing"
+)
+
+// state is a set of register values keyed by name, including PC, HI, LO,
+// FCSR and the floating-point registers "f0" to "f31", and memory words
+// keyed by "@" followed by their address in hex.
+type state map[string]uint32
+
+var conformanceTests = []struct {
+	src  string // a single instruction
+	init state
+	want state
+}{
+	// arithmetic
+	{"add $t0, $t1, $t2", state{"t1": 3, "t2": 0xFFFFFFFE}, state{"t0": 1}},
+	{"addu $t0, $t1, $t2", state{"t1": 0xFFFFFFFF, "t2": 2}, state{"t0": 1}},
+	{"addu $t0, $t1, $t2", state{"t1": 0x7FFFFFFF, "t2": 1}, state{"t0": 0x80000000}},
+	{"sub $t0, $t1, $t2", state{"t1": 1, "t2": 3}, state{"t0": 0xFFFFFFFE}},
+	{"subu $t0, $t1, $t2", state{"t1": 0, "t2": 1}, state{"t0": 0xFFFFFFFF}},
+	{"addi $t0, $t1, -1", state{"t1": 0}, state{"t0": 0xFFFFFFFF}},
+	{"addiu $t0, $t1, -2", state{"t1": 1}, state{"t0": 0xFFFFFFFF}},
+	{"addiu $t0, $t1, 1", state{"t1": 0xFFFFFFFF}, state{"t0": 0}},
+	{"mult $t1, $t2", state{"t1": 0xFFFFFFFF, "t2": 2},
+		state{"HI": 0xFFFFFFFF, "LO": 0xFFFFFFFE}},
+	{"mult $t1, $t2", state{"t1": 0x40000000, "t2": 0x10},
+		state{"HI": 0x4, "LO": 0}},
+	{"multu $t1, $t2", state{"t1": 0xFFFFFFFF, "t2": 2},
+		state{"HI": 1, "LO": 0xFFFFFFFE}},
+	{"div $t1, $t2", state{"t1": 0xFFFFFFF9, "t2": 2},
+		state{"HI": 0xFFFFFFFF, "LO": 0xFFFFFFFD}},
+	{"div $t1, $t2", state{"t1": 0x80000000, "t2": 0xFFFFFFFF},
+		state{"HI": 0, "LO": 0x80000000}},
+	{"divu $t1, $t2", state{"t1": 0xFFFFFFF9, "t2": 2},
+		state{"HI": 1, "LO": 0x7FFFFFFC}},
+	{"mul $t0, $t1, $t2", state{"t1": 0xFFFFFFFF, "t2": 3, "HI": 7},
+		state{"t0": 0xFFFFFFFD, "HI": 7}},
+	{"madd $t1, $t2", state{"t1": 0xFFFFFFFF, "t2": 2, "HI": 0, "LO": 1},
+		state{"HI": 0xFFFFFFFF, "LO": 0xFFFFFFFF}},
+	{"maddu $t1, $t2", state{"t1": 0xFFFFFFFF, "t2": 2, "HI": 0, "LO": 2},
+		state{"HI": 2, "LO": 0}},
+	{"msub $t1, $t2", state{"t1": 0xFFFFFFFF, "t2": 2, "HI": 0, "LO": 0},
+		state{"HI": 0, "LO": 2}},
+	{"msubu $t1, $t2", state{"t1": 1, "t2": 1, "HI": 0, "LO": 0},
+		state{"HI": 0xFFFFFFFF, "LO": 0xFFFFFFFF}},
+	{"mfhi $t0", state{"HI": 0xDEADBEEF}, state{"t0": 0xDEADBEEF}},
+	{"mflo $t0", state{"LO": 0xCAFEBABE}, state{"t0": 0xCAFEBABE}},
+	{"mthi $t0", state{"t0": 0xDEADBEEF}, state{"HI": 0xDEADBEEF}},
+	{"mtlo $t0", state{"t0": 0xCAFEBABE}, state{"LO": 0xCAFEBABE}},
+	// logical
+	{"and $t0, $t1, $t2", state{"t1": 0xF0F0F0F0, "t2": 0xFF00FF00},
+		state{"t0": 0xF000F000}},
+	{"andi $t0, $t1, -1", state{"t1": 0xFFFFFFFF}, state{"t0": 0xFFFF}},
+	{"or $t0, $t1, $t2", state{"t1": 0xF0F0F0F0, "t2": 0x0F000000},
+		state{"t0": 0xFFF0F0F0}},
+	{"ori $t0, $t1, -1", state{"t1": 0}, state{"t0": 0xFFFF}},
+	{"xor $t0, $t1, $t2", state{"t1": 0xFFFF0000, "t2": 0xFF00FF00},
+		state{"t0": 0x00FFFF00}},
+	{"nor $t0, $t1, $t2", state{"t1": 0xF0000000, "t2": 0x0000000F},
+		state{"t0": 0x0FFFFFF0}},
+	{"xori $t0, $t1, -1", state{"t1": 0xFFFF0F0F}, state{"t0": 0xFFFFF0F0}},
+	{"lui $t0, 0x8001", nil, state{"t0": 0x80010000}},
+	{"clz $t0, $t1", state{"t1": 0x00008000}, state{"t0": 16}},
+	{"clz $t0, $t1", state{"t1": 0}, state{"t0": 32}},
+	{"clo $t0, $t1", state{"t1": 0xFFF00000}, state{"t0": 12}},
+	{"ext $t0, $t1, 4, 8", state{"t1": 0x12345678}, state{"t0": 0x67}},
+	{"ext $t0, $t1, 0, 32", state{"t1": 0x12345678}, state{"t0": 0x12345678}},
+	{"ins $t0, $t1, 8, 12", state{"t0": 0xFFFFFFFF, "t1": 0x12345678},
+		state{"t0": 0xFFF678FF}},
+	{"seb $t0, $t1", state{"t1": 0x1280}, state{"t0": 0xFFFFFF80}},
+	{"seh $t0, $t1", state{"t1": 0x17FFF}, state{"t0": 0x7FFF}},
+	{"wsbh $t0, $t1", state{"t1": 0x11223344}, state{"t0": 0x22114433}},
+	{"lui $t0, -1", nil, state{"t0": 0xFFFF0000}},
+	// comparison
+	{"slt $t0, $t1, $t2", state{"t1": 0xFFFFFFFF, "t2": 0}, state{"t0": 1}},
+	{"slt $t0, $t1, $t2", state{"t1": 0, "t2": 0x80000000}, state{"t0": 0}},
+	{"slti $t0, $t1, -1", state{"t1": 0x80000000}, state{"t0": 1}},
+	{"slti $t0, $t1, 5", state{"t1": 5}, state{"t0": 0}},
+	{"sltu $t0, $t1, $t2", state{"t1": 0xFFFFFFFF, "t2": 0}, state{"t0": 0}},
+	{"sltu $t0, $t1, $t2", state{"t1": 1, "t2": 0x80000000}, state{"t0": 1}},
+	{"sltiu $t0, $t1, -1", state{"t1": 0xFFFFFFFE}, state{"t0": 1}},
+	{"sltiu $t0, $t1, 1", state{"t1": 0xFFFFFFFF}, state{"t0": 0}},
+	{"movz $t0, $t1, $t2", state{"t0": 1, "t1": 2, "t2": 0}, state{"t0": 2}},
+	{"movz $t0, $t1, $t2", state{"t0": 1, "t1": 2, "t2": 3}, state{"t0": 1}},
+	{"movn $t0, $t1, $t2", state{"t0": 1, "t1": 2, "t2": 3}, state{"t0": 2}},
+	// shift
+	{"sll $t0, $t1, 4", state{"t1": 0x12345678}, state{"t0": 0x23456780}},
+	{"sll $t0, $t1, 31", state{"t1": 3}, state{"t0": 0x80000000}},
+	{"srl $t0, $t1, 4", state{"t1": 0x80000000}, state{"t0": 0x08000000}},
+	{"sra $t0, $t1, 4", state{"t1": 0x80000000}, state{"t0": 0xF8000000}},
+	{"rotr $t0, $t1, 4", state{"t1": 0x12345678}, state{"t0": 0x81234567}},
+	{"rotrv $t0, $t1, $t2", state{"t1": 0x12345678, "t2": 36},
+		state{"t0": 0x81234567}},
+	{"sllv $t0, $t1, $t2", state{"t1": 1, "t2": 33}, state{"t0": 2}},
+	{"srlv $t0, $t1, $t2", state{"t1": 0xFFFFFFFF, "t2": 0x3F},
+		state{"t0": 1}},
+	{"srav $t0, $t1, $t2", state{"t1": 0x80000000, "t2": 31},
+		state{"t0": 0xFFFFFFFF}},
+	// branch and jump
+	{"beq $t1, $t2, -1", state{"PC": 0x100, "t1": 7, "t2": 7},
+		state{"PC": 0x100}},
+	{"beq $t1, $t2, -1", state{"PC": 0x100, "t1": 7, "t2": 8},
+		state{"PC": 0x104}},
+	{"bne $t1, $t2, 2", state{"PC": 0x100, "t1": 7, "t2": 8},
+		state{"PC": 0x10C}},
+	{"j 0x3FFFFFF", state{"PC": 0x10000000}, state{"PC": 0x1FFFFFFC}},
+	{"jal 0x40", state{"PC": 0x100}, state{"PC": 0x100, "ra": 0x104}},
+	{"jr $t1", state{"t1": 0xFFFFFFFC}, state{"PC": 0xFFFFFFFC}},
+	{"jalr $t1", state{"PC": 0x100, "t1": 0x40}, state{"PC": 0x40, "ra": 0x104}},
+	{"jalr $t1, $t1", state{"PC": 0x100, "t1": 0x40}, state{"PC": 0x40, "t1": 0x104}},
+	{"blez $t1, 3", state{"PC": 0x100, "t1": 0}, state{"PC": 0x110}},
+	{"blez $t1, 3", state{"PC": 0x100, "t1": 1}, state{"PC": 0x104}},
+	{"bgtz $t1, 3", state{"PC": 0x100, "t1": 0x7FFFFFFF}, state{"PC": 0x110}},
+	{"bgtz $t1, 3", state{"PC": 0x100, "t1": 0x80000000}, state{"PC": 0x104}},
+	{"bltz $t1, -2", state{"PC": 0x100, "t1": 0x80000000}, state{"PC": 0xFC}},
+	{"bltz $t1, -2", state{"PC": 0x100, "t1": 0}, state{"PC": 0x104}},
+	{"bgez $t1, -2", state{"PC": 0x100, "t1": 0}, state{"PC": 0xFC}},
+	{"bgez $t1, -2", state{"PC": 0x100, "t1": 0xFFFFFFFF}, state{"PC": 0x104}},
+	{"bltzal $t1, 1", state{"PC": 0x100, "t1": 0xFFFFFFFF},
+		state{"PC": 0x108, "ra": 0x104}},
+	{"bgezal $t1, 1", state{"PC": 0x100, "t1": 0xFFFFFFFF},
+		state{"PC": 0x104, "ra": 0x104}},
+	// load and store
+	{"lw $t0, -4($t1)", state{"t1": DATA_ADDRESS + 4, "@4000000": 0x80000001},
+		state{"t0": 0x80000001}},
+	{"lh $t0, 2($t1)", state{"t1": DATA_ADDRESS, "@4000000": 0x8001FFFF},
+		state{"t0": 0xFFFF8001}},
+	{"lhu $t0, 2($t1)", state{"t1": DATA_ADDRESS, "@4000000": 0x8001FFFF},
+		state{"t0": 0x8001}},
+	{"lb $t0, 3($t1)", state{"t1": DATA_ADDRESS, "@4000000": 0x80000000},
+		state{"t0": 0xFFFFFF80}},
+	{"lbu $t0, 3($t1)", state{"t1": DATA_ADDRESS, "@4000000": 0x80000000},
+		state{"t0": 0x80}},
+	{"lwl $t0, 0($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"t0": 0xDD223344}},
+	{"lwl $t0, 2($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"t0": 0xBBCCDD44}},
+	{"lwl $t0, 3($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"t0": 0xAABBCCDD}},
+	{"lwr $t0, 0($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"t0": 0xAABBCCDD}},
+	{"lwr $t0, 1($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"t0": 0x11AABBCC}},
+	{"lwr $t0, 3($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"t0": 0x112233AA}},
+	{"swl $t0, 0($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"@4000000": 0xAABBCC11}},
+	{"swl $t0, 2($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"@4000000": 0xAA112233}},
+	{"swr $t0, 1($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"@4000000": 0x223344DD}},
+	{"swr $t0, 3($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"@4000000": 0x44BBCCDD}},
+	{"sc $t0, 0($t1)", state{"t0": 0x11223344, "t1": DATA_ADDRESS,
+		"@4000000": 0xAABBCCDD}, state{"t0": 0, "@4000000": 0xAABBCCDD}},
+	{"sw $t0, 0($t1)", state{"t0": 0xDEADBEEF, "t1": DATA_ADDRESS},
+		state{"@4000000": 0xDEADBEEF}},
+	{"sh $t0, 2($t1)", state{"t0": 0xDEADBEEF, "t1": DATA_ADDRESS},
+		state{"@4000000": 0xBEEF0000}},
+	{"sb $t0, 1($t1)", state{"t0": 0xDEADBEEF, "t1": DATA_ADDRESS},
+		state{"@4000000": 0x0000EF00}},
+	// register zero is hard-wired
+	{"addiu $zero, $zero, 1", nil, state{"zero": 0}},
+}
+
+func TestConformance(t *testing.T) {
+	for _, tt := range conformanceTests {
+		conform(t, tt.src, tt.init, tt.want)
+	}
+}
+
+// conform executes the single instruction src on a machine in state
+// init, and checks the resulting state.
+func conform(t *testing.T, src string, init, want state) {
+	m := NewMachine()
+	init.apply(t, m)
+	code, err := Assemble([]byte(src))
+	if err != nil {
+		t.Errorf("%s: %v", src, err)
+		return
+	}
+	inst, err := resolve(binary.LittleEndian.Uint32(code))
+	if err != nil {
+		t.Errorf("%s: %v", src, err)
+		return
+	}
+	inst.f(m, inst.args...)
+	m.advance()
+	want.check(t, src, m)
+}
+
+func (s state) apply(t *testing.T, m *Machine) {
+	for name, v := range s {
+		switch id, fp := fpRegisterTable[name]; {
+		case name[0] == '@':
+			if err := m.m.writeWord(parseAddr(t, name), v); err != nil {
+				t.Fatal(err)
+			}
+		case name == "PC":
+			m.r.PC = v
+		case name == "HI":
+			m.r.HI = v
+		case name == "LO":
+			m.r.LO = v
+		case name == "FCSR":
+			m.r.fcsr = v
+		case fp:
+			m.r.fpr[id] = v
+		default:
+			m.r.write(registerTable[name], v)
+		}
+	}
+}
+
+func (s state) check(t *testing.T, src string, m *Machine) {
+	for name, want := range s {
+		var got uint32
+		switch id, fp := fpRegisterTable[name]; {
+		case name[0] == '@':
+			var err error
+			if got, err = m.m.readWord(parseAddr(t, name)); err != nil {
+				t.Fatal(err)
+			}
+		case name == "PC":
+			got = m.r.PC
+		case name == "HI":
+			got = m.r.HI
+		case name == "LO":
+			got = m.r.LO
+		case name == "FCSR":
+			got = m.r.fcsr
+		case fp:
+			got = m.r.fpr[id]
+		default:
+			got = m.r.read(registerTable[name])
+		}
+		if got != want {
+			t.Errorf("%s: expect %s = %#x, got %#x", src, name, want, got)
+		}
+	}
+}
+
+func parseAddr(t *testing.T, name string) uint32 {
+	addr, err := strconv.ParseUint(name[1:], 16, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uint32(addr)
+}
+
+func TestUnalignedCopy(t *testing.T) {
+	// Copy the unaligned word at src+1 to dst+2
+	em := NewEmulator()
+	if err := em.LoadAndRun(assembleString(t, `.data
+src:	.byte 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88
+dst:	.word 0, 0
+.text
+main:
+	la $t0, src
+	la $t1, dst
+	lwr $t2, 1($t0)
+	lwl $t2, 4($t0)
+	swr $t2, 2($t1)
+	swl $t2, 5($t1)
+	li $v0, 10
+	syscall`)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadReg("t2"); v != 0x55443322 {
+		t.Errorf("expect $t2 = 0x55443322, got %#x", v)
+	}
+	for i, want := range []uint32{0x33220000, 0x5544} {
+		if v, _ := em.ReadMemory(DATA_ADDRESS + 8 + uint32(i)*4); v != want {
+			t.Errorf("expect dst word %d = %#x, got %#x", i, want, v)
+		}
+	}
+}
+
+func TestLoadLinked(t *testing.T) {
+	// The first sc fails after a store to the linked word, the second
+	// succeeds.
+	em := NewEmulator()
+	if err := em.LoadAndRun(assembleString(t, `.data
+counter:	.word 41
+.text
+main:
+	la $t0, counter
+	li $s0, 0
+retry:
+	ll $t1, 0($t0)
+	addi $t1, $t1, 1
+	bne $s0, $zero, store
+	sw $zero, 4($t0)
+	sw $zero, 0($t0)
+store:
+	sc $t1, 0($t0)
+	addi $s0, $s0, 1
+	beq $t1, $zero, retry
+	li $v0, 10
+	syscall`)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadReg("s0"); v != 2 {
+		t.Errorf("expect 2 attempts, got %d", v)
+	}
+	if v, _ := em.ReadMemory(DATA_ADDRESS); v != 1 {
+		t.Errorf("expect counter = 1, got %d", v)
+	}
+}
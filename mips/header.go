@@ -0,0 +1,104 @@
+package mips
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var (
+	headerPattern = regexp.MustCompile(`^text:([0-9]+),data:([0-9]+),main:([0-9]+)` +
+		`(?:,ktext:([0-9]+),kdata:([0-9]+))?` +
+		`(?:,textaddr:([0-9]+),dataaddr:([0-9]+))?` +
+		`(?:,endian:(big|little))?$`)
+)
+
+// objectHeader is the first line of an object file. It holds the
+// offsets of segments following the header, and the offset of main
+// in the text segment. Kernel segments are optional. The addresses of
+// text and .data the object is assembled for are recorded unless they
+// are those of DefaultLayout, and so is the byte order unless it is
+// little-endian.
+type objectHeader struct {
+	text, data, main   int
+	kernel             bool // the object has kernel segments
+	ktext, kdata       int
+	textAddr, dataAddr uint32
+	order              binary.ByteOrder
+}
+
+func parseHeader(line string) (*objectHeader, error) {
+	sub := headerPattern.FindStringSubmatch(line)
+	if sub == nil {
+		return nil, errors.New("invalid header")
+	}
+	var offsets [5]int
+	for i, s := range sub[1:6] {
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		offsets[i] = int(n)
+	}
+	h := &objectHeader{
+		text:     offsets[0],
+		data:     offsets[1],
+		main:     offsets[2],
+		ktext:    offsets[3],
+		kdata:    offsets[4],
+		kernel:   sub[4] != "",
+		textAddr: DefaultLayout.Text,
+		dataAddr: DefaultLayout.Data,
+		order:    binary.LittleEndian,
+	}
+	if sub[6] != "" {
+		text, err := strconv.ParseUint(sub[6], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		data, err := strconv.ParseUint(sub[7], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		h.textAddr, h.dataAddr = uint32(text), uint32(data)
+	}
+	if sub[8] == "big" {
+		h.order = binary.BigEndian
+	}
+	if h.kernel && (h.ktext < h.data || h.kdata < h.ktext) {
+		return nil, errors.New("invalid header: kernel offset out of range")
+	}
+	return h, nil
+}
+
+// hasKernel reports whether the object has kernel segments
+func (h *objectHeader) hasKernel() bool {
+	return h.kernel
+}
+
+// dataEnd returns the end offset of the data segment
+func (h *objectHeader) dataEnd(size int) int {
+	if h.hasKernel() {
+		return h.ktext
+	}
+	return size
+}
+
+func (h *objectHeader) String() string {
+	s := fmt.Sprintf("text:%d,data:%d,main:%d", h.text, h.data, h.main)
+	if h.hasKernel() {
+		s += fmt.Sprintf(",ktext:%d,kdata:%d", h.ktext, h.kdata)
+	}
+	if h.textAddr != DefaultLayout.Text || h.dataAddr != DefaultLayout.Data {
+		s += fmt.Sprintf(",textaddr:%d,dataaddr:%d", h.textAddr, h.dataAddr)
+	}
+	if h.order == binary.BigEndian {
+		s += ",endian:big"
+	}
+	return s
+}
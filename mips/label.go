@@ -10,9 +10,14 @@ func (p *parser) labelFilter(items <-chan parseItem) <-chan parseItem {
 }
 
 func (p *parser) readAllLabel(items <-chan parseItem) {
-	textAddress := TEXT_ADDRESS
-	dataAddress := DATA_ADDRESS
+	textAddress := int(p.layout.Text)
+	dataAddress := int(p.layout.Data)
+	ktextAddress := KTEXT_ADDRESS
+	kdataAddress := KDATA_ADDRESS
 	addr := &textAddress
+	// In reorder mode, the delay slot of each branch and load is filled
+	// with a nop
+	reorder := false
 LOOP:
 	for item := range items {
 		item.address = *addr
@@ -35,12 +40,35 @@ LOOP:
 				addr = &textAddress
 			case "data":
 				addr = &dataAddress
+			case "ktext", "kdata":
+				addr = &ktextAddress
+				if item.directive == "kdata" {
+					addr = &kdataAddress
+				}
+				if item.data != nil {
+					if item.data.(int) < *addr {
+						i := parseItem{
+							typ: itemError,
+							err: fmt.Sprintf("line %d: address %#x is below "+
+								"current %s address %#x", item.line+1,
+								item.data.(int), item.directive, *addr),
+						}
+						p.itemList.Init()
+						p.itemList.PushBack(i)
+						return
+					}
+					*addr = item.data.(int)
+				}
 			case "byte":
 				*addr += len(item.data.([]int))
 			case "half":
 				*addr += len(item.data.([]int)) << 1
 			case "word":
 				*addr += len(item.data.([]int)) << 2
+			case "float":
+				*addr += len(item.data.([]float64)) << 2
+			case "double":
+				*addr += len(item.data.([]float64)) << 3
 			case "space":
 				*addr += item.data.(int)
 			case "align":
@@ -54,6 +82,13 @@ LOOP:
 				*addr += len(item.data.(string)) + 1
 			case "globl":
 				item.label = item.data.(string)
+			case "set":
+				switch item.data.(string) {
+				case "reorder":
+					reorder = true
+				case "noreorder":
+					reorder = false
+				}
 			}
 		case itemInst:
 			inst := instructionTable[item.instruction]
@@ -62,6 +97,10 @@ LOOP:
 			} else {
 				*addr += 4
 			}
+			if reorder && (inst.branch || inst.load) {
+				item.fillSlot = true
+				*addr += 4
+			}
 		case itemError:
 			p.itemList.Init()
 			p.itemList.PushBack(item)
@@ -84,7 +123,7 @@ func (p *parser) replaceLabel() <-chan parseItem {
 				if item.label != "" {
 					if l, ok := p.labels[item.label]; ok {
 						if instructionTable[item.instruction].typ == "J" {
-							item.imme = l.address >> 2
+							item.imme = (l.address >> 2) & 0x3FFFFFF
 						} else {
 							item.imme = (l.address - (item.address + 4)) >> 2
 						}
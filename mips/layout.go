@@ -0,0 +1,65 @@
+package mips
+
+import "errors"
+
+// MemoryLayout gives the addresses of the user segments and the initial
+// values of $gp and $sp. The kernel segments are always at KTEXT_ADDRESS
+// and KDATA_ADDRESS.
+type MemoryLayout struct {
+	Text       uint32 // base of the text segment, which ends at DataBase
+	DataBase   uint32 // base of the data segment
+	Data       uint32 // address of .data, in the data segment
+	DataLimit  uint32 // end of the data segment, up to which the heap grows
+	GP         uint32 // initial $gp
+	SP         uint32 // initial $sp
+	StackBase  uint32 // highest word of the stack, which grows down
+	StackLimit uint32 // lowest address of the stack
+}
+
+var (
+	// DefaultLayout is the layout vmips has always used
+	DefaultLayout = MemoryLayout{
+		Text:       TEXT_ADDRESS,
+		DataBase:   DATA_ADDRESS,
+		Data:       DATA_ADDRESS,
+		DataLimit:  MAX_DATA_ADDR,
+		GP:         DATA_ADDRESS + 0x8000,
+		SP:         STACK_ADDRESS,
+		StackBase:  STACK_ADDRESS,
+		StackLimit: MAX_DATA_ADDR,
+	}
+	// MARSLayout is the default layout of MARS
+	MARSLayout = MemoryLayout{
+		Text:       0x00400000,
+		DataBase:   0x10000000,
+		Data:       0x10010000,
+		DataLimit:  0x7F000000,
+		GP:         0x10008000,
+		SP:         0x7FFFEFFC,
+		StackBase:  0x7FFFFFFC,
+		StackLimit: 0x7F000000,
+	}
+	// SPIMLayout is the layout of SPIM
+	SPIMLayout = MemoryLayout{
+		Text:       0x00400000,
+		DataBase:   0x10000000,
+		Data:       0x10000000,
+		DataLimit:  0x7F000000,
+		GP:         0x10008000,
+		SP:         0x7FFFFFFC,
+		StackBase:  0x7FFFFFFC,
+		StackLimit: 0x7F000000,
+	}
+)
+
+// validate checks that the segments are ordered, word-aligned and below
+// the kernel segments.
+func (l MemoryLayout) validate() error {
+	if l.Text < l.DataBase && l.DataBase <= l.Data && l.Data < l.DataLimit &&
+		l.DataLimit <= l.StackLimit && l.StackLimit <= l.SP &&
+		l.SP <= l.StackBase && l.StackBase < KTEXT_ADDRESS &&
+		(l.Text|l.DataBase|l.Data|l.StackBase)&3 == 0 {
+		return nil
+	}
+	return errors.New("invalid memory layout")
+}
@@ -0,0 +1,81 @@
+package mips
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+const layoutProgram = `.data
+x:	.word 42
+.text
+.globl main
+f:
+	jr $ra
+main:
+	jal f
+	la $t0, x
+	lw $t1, 0($t0)
+	sw $t1, -4($sp)
+	li $v0, 10
+	syscall`
+
+func TestLayout(t *testing.T) {
+	for _, l := range []MemoryLayout{DefaultLayout, MARSLayout, SPIMLayout} {
+		a := NewAssembler(strings.NewReader(layoutProgram))
+		if err := a.SetLayout(l); err != nil {
+			t.Fatal(err)
+		}
+		obj, err := a.Assemble()
+		if err != nil {
+			t.Fatal(err)
+		}
+		em := NewEmulator(WithLayout(l))
+		if err := em.LoadAndRun(obj); err != nil {
+			t.Fatalf("%#x: %v", l.Text, err)
+		}
+		for reg, want := range map[string]uint32{
+			"t0": l.Data,
+			"t1": 42,
+			"ra": l.Text + 8,
+			"gp": l.GP,
+			"sp": l.SP,
+		} {
+			if v, _ := em.ReadReg(reg); v != want {
+				t.Errorf("%#x: expect $%s = %#x, got %#x", l.Text, reg, want, v)
+			}
+		}
+
+		// the object only runs in the same layout
+		other := DefaultLayout
+		if l == DefaultLayout {
+			other = MARSLayout
+		}
+		if err := NewEmulator(WithLayout(other)).Load(obj); err == nil {
+			t.Errorf("%#x: expect an error loading in another layout", l.Text)
+		}
+	}
+}
+
+func TestLayoutHeader(t *testing.T) {
+	h := &objectHeader{data: 8, main: 4, textAddr: MARSLayout.Text, dataAddr: MARSLayout.Data,
+		order: binary.LittleEndian}
+	s := h.String()
+	if s != "text:0,data:8,main:4,textaddr:4194304,dataaddr:268500992" {
+		t.Errorf("unexpected header %q", s)
+	}
+	if h2, err := parseHeader(s); err != nil || *h2 != *h {
+		t.Errorf("expect %+v, got %+v, %v", h, h2, err)
+	}
+	if h, _ := parseHeader("text:0,data:8,main:4"); h.textAddr != TEXT_ADDRESS || h.dataAddr != DATA_ADDRESS {
+		t.Errorf("expect the addresses of DefaultLayout, got %+v", h)
+	}
+}
+
+func TestInvalidLayout(t *testing.T) {
+	l := MARSLayout
+	l.Data = l.DataBase - 4
+	if err := NewAssembler(strings.NewReader("")).SetLayout(l); err == nil {
+		t.Error("expect an invalid layout to be rejected")
+	}
+}
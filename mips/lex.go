@@ -28,6 +28,8 @@ const (
 	tokenLabel           // label reference
 	tokenLabelDef        // label definition, "Next:"
 	tokenEndline         // end line
+	tokenFloat           // floating-point number, "1.5e3"
+	tokenFPRegister      // floating-point register, "$f0"
 )
 
 // token represents a token, it holds type and value of lex items
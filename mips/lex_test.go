@@ -36,3 +36,17 @@ func TestLex(t *testing.T) {
 		// fmt.Printf("%s: %s\n", token.typ, token)
 	}
 }
+
+func TestLexFloat(t *testing.T) {
+	ch := lex(bufio.NewReader(bytes.NewBufferString("add.s $f0, $f31, -1.5e3, 2., 0x10, 7")))
+	want := []tokenType{tokenInstruction, tokenFPRegister, tokenComma,
+		tokenFPRegister, tokenComma, tokenFloat, tokenComma, tokenFloat,
+		tokenComma, tokenInteger, tokenComma, tokenInteger, tokenEOF}
+	i := 0
+	for token := range ch {
+		if i >= len(want) || token.typ != want[i] {
+			t.Fatalf("token %d: unexpected %s(%s)", i, token, token.typ)
+		}
+		i++
+	}
+}
@@ -0,0 +1,144 @@
+package mips
+
+import (
+	"context"
+	"io"
+)
+
+// Limit is a resource limited by Limits
+type Limit int
+
+const (
+	LimitNone Limit = iota
+	LimitInstructions
+	LimitMemory
+	LimitOutput
+	LimitInput
+)
+
+var limitNames = map[Limit]string{
+	LimitNone:         "no",
+	LimitInstructions: "instruction",
+	LimitMemory:       "memory",
+	LimitOutput:       "output",
+	LimitInput:        "input",
+}
+
+func (l Limit) String() string {
+	return limitNames[l]
+}
+
+// LimitError stops a program exceeding a limit
+type LimitError struct {
+	Limit Limit
+}
+
+func (e *LimitError) Error() string {
+	return e.Limit.String() + " limit exceeded"
+}
+
+// Limits limits the resources of a program, for running untrusted code.
+// Zero means no limit.
+type Limits struct {
+	// Instructions executed by all cores. Read and write syscalls also
+	// count an instruction for each chunk they copy after the first.
+	Instructions uint64
+	// Memory is the bytes of guest memory pages allocated, rounded up to
+	// pages. Buffers of the emulator and file contents are not counted.
+	Memory int
+	Output int64 // bytes written to the standard output and error, and to files
+	Input  int64 // bytes read from the standard input
+}
+
+// Result is the result of running a program
+type Result struct {
+	Status       ExitStatus
+	Instructions uint64 // instructions counted against the limit so far
+	Limit        Limit  // the limit exceeded if Status is EXIT_LIMIT
+	ExitCode     int    // code passed to exit2
+	Err          error  // nil if the program terminated normally
+}
+
+// limiter counts the resources used by a program, and stops it when it
+// exceeds a limit. It is shared by the cores.
+type limiter struct {
+	Limits
+	instructions  uint64
+	output, input int64
+}
+
+// instruction counts an instruction about to be executed
+func (l *limiter) instruction() {
+	if l.Instructions != 0 && l.instructions >= l.Instructions {
+		panic(&LimitError{LimitInstructions})
+	}
+	l.instructions++
+}
+
+// chunk counts a chunk copied by a read or write syscall, so that long
+// syscalls use up the instruction limit
+func (l *limiter) chunk() {
+	l.instruction()
+}
+
+// write counts n bytes about to be written
+func (l *limiter) write(n int) {
+	if l.output += int64(n); l.Output != 0 && l.output > l.Output {
+		panic(&LimitError{LimitOutput})
+	}
+}
+
+// read counts n bytes read
+func (l *limiter) read(n int) {
+	if l.input += int64(n); l.Input != 0 && l.input > l.Input {
+		panic(&LimitError{LimitInput})
+	}
+}
+
+// limitWriter counts the output of a program. Programs write only while
+// executing instructions, so that exceeding the limit stops them.
+type limitWriter struct {
+	w io.Writer
+	l *limiter
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	w.l.write(len(p))
+	return w.w.Write(p)
+}
+
+// limitReader counts the input read by syscall handlers
+type limitReader struct {
+	r io.Reader
+	l *limiter
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.l.read(n)
+	return n, err
+}
+
+// WithLimits limits the resources of the program. A program exceeding
+// a limit stops with EXIT_LIMIT and a *LimitError.
+func WithLimits(l Limits) Option {
+	return func(e *Emulator) {
+		e.machine.limit.Limits = l
+		e.machine.m.pageLimit = (l.Memory + pageSize - 1) / pageSize
+	}
+}
+
+// Execute runs the program like Run, and reports the result
+func (e *Emulator) Execute(ctx context.Context) Result {
+	status, err := e.Run(ctx)
+	r := Result{
+		Status:       status,
+		Instructions: e.machine.limit.instructions,
+		ExitCode:     e.machine.exitCode,
+		Err:          err,
+	}
+	if le, ok := err.(*LimitError); ok {
+		r.Limit = le.Limit
+	}
+	return r
+}
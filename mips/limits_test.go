@@ -0,0 +1,112 @@
+package mips
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+// bigWrite writes 256 MB of the stack, which is not allocated, to a file
+const bigWrite = `.data
+name:	.asciiz "big"
+.text
+main:
+	la $a0, name
+	li $a1, 1
+	li $v0, 13
+	syscall
+	move $a0, $v0
+	li $a1, 0x8000000
+	li $a2, 0x10000000
+	li $v0, 15
+	syscall
+	li $v0, 10
+	syscall`
+
+func TestLimits(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		limits Limits
+		prog   string
+		limit  Limit
+	}{
+		{"loop", Limits{Instructions: 1000}, `.text
+main:
+	j main`, LimitInstructions},
+		{"memory", Limits{Memory: 16 * pageSize}, `.text
+main:
+	sw $zero, 0($sp)
+	addi $sp, $sp, -4096
+	j main`, LimitMemory},
+		{"output", Limits{Output: 10}, `.text
+main:
+	li $a0, 65
+	li $v0, 11
+	syscall
+	j main`, LimitOutput},
+		{"input", Limits{Input: 3}, `.text
+main:
+	li $v0, 12
+	syscall
+	j main`, LimitInput},
+		{"file", Limits{Output: 1000}, bigWrite, LimitOutput},
+		{"long syscall", Limits{Instructions: 100}, bigWrite, LimitInstructions},
+	} {
+		out := new(bytes.Buffer)
+		em := NewEmulator(
+			WithLimits(c.limits),
+			WithStdin(strings.NewReader("abcdef")),
+			WithStdout(out),
+		)
+		if err := em.Load(assembleString(t, c.prog)); err != nil {
+			t.Fatal(err)
+		}
+		r := em.Execute(context.Background())
+		if r.Status != EXIT_LIMIT || r.Limit != c.limit {
+			t.Errorf("%s: expect EXIT_LIMIT by the %s limit, got %s(%v) by the %s limit",
+				c.name, c.limit, r.Status, r.Err, r.Limit)
+		}
+		if le, ok := r.Err.(*LimitError); !ok || le.Limit != c.limit {
+			t.Errorf("%s: expect a LimitError, got %v", c.name, r.Err)
+		}
+		if c.limit == LimitInstructions && r.Instructions != c.limits.Instructions {
+			t.Errorf("%s: expect %d instructions, got %d", c.name, c.limits.Instructions, r.Instructions)
+		}
+		if c.name == "output" && out.String() != "AAAAAAAAAA" {
+			t.Errorf("%s: expect 10 bytes of output, got %q", c.name, out.String())
+		}
+		if c.limit == LimitMemory && em.MemoryStats().Pages > 16 {
+			t.Errorf("%s: expect at most 16 pages, got %d", c.name, em.MemoryStats().Pages)
+		}
+	}
+}
+
+func TestExecute(t *testing.T) {
+	em := NewEmulator(WithLimits(Limits{Instructions: 1000}))
+	if err := em.Load(assembleString(t, `.text
+main:
+	li $a0, 3
+	li $v0, 17
+	syscall`)); err != nil {
+		t.Fatal(err)
+	}
+	r := em.Execute(context.Background())
+	if r != (Result{Status: EXIT_NORMAL, Instructions: 5, ExitCode: 3}) {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	em = NewEmulator()
+	if err := em.Load(assembleString(t, loopProgram)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = em.Execute(ctx)
+	if r.Status != EXIT_INT || r.Err != context.Canceled || r.Limit != LimitNone {
+		t.Errorf("expect EXIT_INT when canceled, got %+v", r)
+	}
+	if r.Instructions != pollInterval {
+		t.Errorf("expect %d instructions before polling, got %d", pollInterval, r.Instructions)
+	}
+}
@@ -1,214 +1,332 @@
 package mips
 
-import "errors"
-
-type addrSeg int
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+)
 
+// Addresses of segments. User segments are placed as in DefaultLayout
+// only by default.
 const (
 	TEXT_ADDRESS   = 0x0
 	DATA_ADDRESS   = 0x4000000
 	MAX_DATA_ADDR  = 0x8000000
 	STACK_ADDRESS  = 0x7F000000
-	MIN_STACK_ADDR = 0x7E000000
-	textSegment    = 1 << iota
-	dataSegment
-	stackSegment
+	KTEXT_ADDRESS  = 0x80000000
+	EXC_VECTOR     = 0x80000180 // entry of the exception handler
+	KDATA_ADDRESS  = 0x90000000
+	MAX_KDATA_ADDR = 0xA0000000
+	// registers of the memory-mapped console mapped by WithConsole
+	CONSOLE_ADDRESS = 0xFFFF0000
 )
 
-/*
-   +-----------+   STACK_ADDRESS
-   |   stack   |
-   +-----------+
-   |  unmaped  |
-   |   ...     |
-   +-----------+
-   |   data    |
-   +-----------+   DATA_ADDRESS
-   |   text    |
-   +-----------+   TEXT_ADDRESS
-*/
-
-type virtualMemory struct {
-	text, data, stack []byte
-}
-
 type registerFile struct {
-	general    [32]int
-	HI, LO, PC int
+	general    [32]uint32
+	HI, LO, PC uint32
+	fpr        [32]uint32 // floating-point registers of coprocessor 1
+	fcsr       uint32     // floating-point control and status
+	// general registers written since the last instruction ended, so
+	// that a write in a load delay slot wins over the load
+	written uint32
 }
 
 type Machine struct {
-	m    *virtualMemory
-	r    *registerFile
-	exit bool
+	id      int // core ID
+	m       *virtualMemory
+	r       *registerFile
+	c0      [32]uint32 // coprocessor 0
+	handler bool       // exception handler is loaded at EXC_VECTOR
+	exit    bool
+	// sleep is requested by the sleep system call, for the emulator
+	sleep time.Duration
+
+	exitCode int // set by the exit2 system call
+	// standard streams of the program
+	stdin          *bufio.Reader
+	stdout, stderr io.Writer
+	// files opened by the program by descriptor, and random generators
+	// by id
+	fs    FileSystem
+	files map[uint32]File
+	// syscall services registered in addition to syscallTable
+	syscalls map[uint32]func(*Machine)
+	rands    map[uint32]*rand.Rand
+	// resources used by the program, shared by the cores
+	limit *limiter
+
+	branchDelay bool // branches take effect after their delay slot
+	loadDelay   bool // loaded values are not visible to the next instruction
+
+	// control transfer requested by the current instruction
+	jump, jumpDelayed bool
+	jumpTarget        uint32
+	// set while executing a delay slot, which then continues at slotTarget
+	inSlot     bool
+	slotTarget uint32
+	// loads waiting for the end of their delay slot
+	load, delayedLoad pendingLoad
+	// link set by ll for sc, cleared by stores to the linked word and by
+	// exceptions
+	llBit  bool
+	llAddr uint32
+	// cores sharing the memory, including this one
+	cores []*Machine
+}
+
+type pendingLoad struct {
+	valid bool
+	reg   int
+	value uint32
 }
 
 func NewMachine() *Machine {
-	return &Machine{
-		m: &virtualMemory{
-			text:  make([]byte, 1<<12),
-			data:  make([]byte, 1<<12),
-			stack: make([]byte, 1<<12),
-		},
-		r: new(registerFile),
+	m := &Machine{
+		m:        newVirtualMemory(),
+		r:        new(registerFile),
+		stdin:    bufio.NewReader(os.Stdin),
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+		fs:       NewMemFS(nil, ReadWrite),
+		files:    make(map[uint32]File),
+		syscalls: make(map[uint32]func(*Machine)),
+		rands:    make(map[uint32]*rand.Rand),
+		limit:    new(limiter),
 	}
+	m.c0[c0Status] = statusInit
+	m.cores = []*Machine{m}
+	return m
 }
 
-func (rf *registerFile) read(id int) int {
-	id &= 0x1F
-	if id == 0 {
-		return 0
+// newCore returns a core of the same machine as m, which has not run
+// yet. It has its own registers and shares memory, streams and settings
+// with m.
+func (m *Machine) newCore(id int) *Machine {
+	c := *m
+	c.id = id
+	c.r = new(registerFile)
+	return &c
+}
+
+// branch transfers control to target, after the delay slot if
+// branch delay is enabled.
+func (m *Machine) branch(target uint32) {
+	m.jump, m.jumpDelayed, m.jumpTarget = true, m.branchDelay, target
+}
+
+// jumpNow transfers control to target without a delay slot
+func (m *Machine) jumpNow(target uint32) {
+	m.jump, m.jumpDelayed, m.jumpTarget = true, false, target
+}
+
+// link returns the return address saved by jump-and-link instructions,
+// which skips the delay slot if branch delay is enabled.
+func (m *Machine) link() uint32 {
+	if m.branchDelay {
+		return m.r.PC + 8
 	}
-	return rf.general[id]
+	return m.r.PC + 4
 }
 
-func (rf *registerFile) write(id int, value int) {
-	id &= 0x1F
-	if id == 0 {
+// writeLoad writes the result of a load to register id, at the end of
+// the next instruction if load delay is enabled.
+func (m *Machine) writeLoad(id int, value uint32) {
+	if m.loadDelay {
+		m.load = pendingLoad{true, id, value}
 		return
 	}
-	rf.general[id] = value
+	m.r.write(id, value)
 }
 
-func (m *virtualMemory) read(addr int) (byte, error) {
-	actual, seg, err := m.transfer(addr)
-	if err != nil {
-		return 0, err
-	}
-	switch seg {
-	case textSegment:
-		return m.text[actual], nil
-	case dataSegment:
-		return m.data[actual], nil
-	case stackSegment:
-		return m.stack[actual], nil
+// advance moves PC past the executed instruction and retires loads
+// whose delay slot has ended.
+func (m *Machine) advance() {
+	switch {
+	case m.jump && m.jumpDelayed && !m.inSlot:
+		m.inSlot, m.slotTarget = true, m.jumpTarget
+		m.r.PC += 4
+	case m.jump:
+		m.inSlot = false
+		m.r.PC = m.jumpTarget
+	case m.inSlot:
+		m.inSlot = false
+		m.r.PC = m.slotTarget
 	default:
-		// shouldn't get here
-		panic("something wrong...")
+		m.r.PC += 4
 	}
+	m.jump = false
+	m.retireLoad()
+	m.delayedLoad, m.load = m.load, pendingLoad{}
+	m.r.written = 0
 }
 
-func (m *virtualMemory) write(addr int, value byte) error {
-	actual, seg, err := m.transfer(addr)
-	if err != nil {
-		return err
-	}
-	switch seg {
-	case textSegment:
-		m.text[actual] = value
-	case dataSegment:
-		m.data[actual] = value
-	case stackSegment:
-		m.stack[actual] = value
-	default:
-		panic("something wrong...")
+// loadMerge returns register id as seen by lwl and lwr, which merge
+// with the result of a preceding load still in its delay slot.
+func (m *Machine) loadMerge(id int) uint32 {
+	if m.delayedLoad.valid && m.delayedLoad.reg == id {
+		return m.delayedLoad.value
 	}
-	return nil
+	return m.r.read(id)
 }
 
-func (m *virtualMemory) readHalf(addr int) (int, error) {
-	b1, err := m.read(addr)
-	if err != nil {
-		return 0, err
+// retireLoad writes the load in its delay slot, unless the instruction
+// in the slot wrote the same register.
+func (m *Machine) retireLoad() {
+	if m.delayedLoad.valid {
+		if m.r.written&(1<<m.delayedLoad.reg) == 0 {
+			m.r.write(m.delayedLoad.reg, m.delayedLoad.value)
+		}
+		m.delayedLoad.valid = false
 	}
-	b2, err := m.read(addr + 1)
+}
+
+// kernelMode reports whether the processor runs in kernel mode
+func (m *Machine) kernelMode() bool {
+	status := m.c0[c0Status]
+	return status&statusUM == 0 || status&statusEXL != 0
+}
+
+// accessible reports whether addr can be accessed in the current mode.
+// Devices are accessible in user mode.
+func (m *Machine) accessible(addr uint32) bool {
+	return addr < KTEXT_ADDRESS || m.kernelMode() || m.m.device(addr) != nil
+}
+
+// loadWord reads a word for a load, raising AdEL if addr is unaligned,
+// reserved for the kernel or not readable, and DBE if it is not mapped.
+func (m *Machine) loadWord(addr uint32) uint32 {
+	if addr&3 != 0 || !m.accessible(addr) || !m.readable(addr) {
+		m.raise(EXC_ADEL, addr)
+	}
+	i, err := m.m.readWord(addr)
 	if err != nil {
-		return 0, err
+		m.raise(EXC_DBE, addr)
 	}
-	i := int(b2)
-	i <<= 8
-	i &= int(b1)
-	return i, nil
+	return i
 }
 
-func (m *virtualMemory) writeHalf(addr int, value int) error {
-	err := m.write(addr, byte(value&0xFF))
+func (m *Machine) loadHalf(addr uint32) uint16 {
+	if addr&1 != 0 || !m.accessible(addr) || !m.readable(addr) {
+		m.raise(EXC_ADEL, addr)
+	}
+	i, err := m.m.readHalf(addr)
 	if err != nil {
-		return err
+		m.raise(EXC_DBE, addr)
 	}
-	return m.write(addr+1, byte((value>>8)&0xFF))
+	return i
 }
 
-func (m *virtualMemory) readWord(addr int) (int, error) {
-	b0, err := m.read(addr)
-	if err != nil {
-		return 0, err
+func (m *Machine) loadByte(addr uint32) byte {
+	if !m.accessible(addr) || !m.readable(addr) {
+		m.raise(EXC_ADEL, addr)
 	}
-	b1, err := m.read(addr + 1)
+	b, err := m.m.read(addr)
 	if err != nil {
-		return 0, err
+		m.raise(EXC_DBE, addr)
 	}
-	b2, err := m.read(addr + 2)
-	if err != nil {
-		return 0, err
+	return b
+}
+
+// storeWord writes a word for a store, raising AdES if addr is
+// unaligned, reserved for the kernel or read-only, and DBE if it is not
+// mapped.
+func (m *Machine) storeWord(addr uint32, value uint32) {
+	if addr&3 != 0 || !m.accessible(addr) || !m.writable(addr) {
+		m.raise(EXC_ADES, addr)
 	}
-	b3, err := m.read(addr + 3)
-	if err != nil {
-		return 0, err
+	if err := m.m.writeWord(addr, value); err != nil {
+		m.storeFault(addr, err)
 	}
-	i := int(b3)
-	i = i << 8
-	i |= int(b2)
-	i = i << 8
-	i |= int(b1)
-	i = i << 8
-	i |= int(b0)
-	return i, nil
+	m.clearLink(addr)
 }
 
-func (m *virtualMemory) writeWord(addr int, value int) error {
-	err := m.write(addr, byte(value&0xFF))
-	if err != nil {
-		return err
+func (m *Machine) storeHalf(addr uint32, value uint16) {
+	if addr&1 != 0 || !m.accessible(addr) || !m.writable(addr) {
+		m.raise(EXC_ADES, addr)
 	}
-	err = m.write(addr+1, byte((value>>8)&0xFF))
-	if err != nil {
-		return err
+	if err := m.m.writeHalf(addr, value); err != nil {
+		m.storeFault(addr, err)
+	}
+	m.clearLink(addr)
+}
+
+func (m *Machine) storeByte(addr uint32, value byte) {
+	if !m.accessible(addr) || !m.writable(addr) {
+		m.raise(EXC_ADES, addr)
+	}
+	if err := m.m.write(addr, value); err != nil {
+		m.storeFault(addr, err)
+	}
+	m.clearLink(addr)
+}
+
+// mergeWord reads the word containing addr for swl and swr to merge
+// into, raising the exceptions of a store to addr.
+func (m *Machine) mergeWord(addr uint32) uint32 {
+	if !m.accessible(addr) || !m.writable(addr) {
+		m.raise(EXC_ADES, addr)
 	}
-	err = m.write(addr+2, byte((value>>16)&0xFF))
+	w, err := m.m.readWord(addr &^ 3)
 	if err != nil {
-		return err
+		m.raise(EXC_DBE, addr)
 	}
-	return m.write(addr+3, byte((value>>24)&0xFF))
+	return w
 }
 
-func (m *virtualMemory) writeBytes(addr int, s []byte) error {
-	for i := 0; i < len(s); i++ {
-		err := m.write(addr+i, s[i])
-		if err != nil {
-			return err
-		}
+// storeFault raises DBE for a failed store, unless it exceeded the
+// memory limit, which stops the program.
+func (m *Machine) storeFault(addr uint32, err error) {
+	if le, ok := err.(*LimitError); ok {
+		panic(le)
 	}
-	return nil
+	m.raise(EXC_DBE, addr)
 }
 
-func (m *virtualMemory) transfer(virtual int) (int, addrSeg, error) {
-	switch {
-	case virtual >= TEXT_ADDRESS && virtual < DATA_ADDRESS:
-		actual := virtual - TEXT_ADDRESS
-		for actual >= len(m.text) {
-			text := make([]byte, len(m.text)<<1)
-			copy(text, m.text)
-			m.text = text
-		}
-		return actual, textSegment, nil
-	case virtual >= DATA_ADDRESS && virtual < MAX_DATA_ADDR:
-		actual := virtual - DATA_ADDRESS
-		for actual >= len(m.data) {
-			data := make([]byte, len(m.data)<<1)
-			copy(data, m.data)
-			m.data = data
-		}
-		return actual, dataSegment, nil
-	case virtual >= MIN_STACK_ADDR && virtual <= STACK_ADDRESS:
-		actual := STACK_ADDRESS - virtual
-		for actual >= len(m.stack) {
-			stack := make([]byte, len(m.stack)<<1)
-			copy(stack, m.stack)
-			m.stack = stack
+// readable and writable report whether addr may be read or written, or
+// is not mapped
+func (m *Machine) readable(addr uint32) bool {
+	p := m.m.perm(addr)
+	return p == 0 || p&PermRead != 0
+}
+
+func (m *Machine) writable(addr uint32) bool {
+	p := m.m.perm(addr)
+	return p == 0 || p&PermWrite != 0
+}
+
+func (rf *registerFile) read(id int) uint32 {
+	id &= 0x1F
+	if id == 0 {
+		return 0
+	}
+	return rf.general[id]
+}
+
+func (rf *registerFile) write(id int, value uint32) {
+	id &= 0x1F
+	if id == 0 {
+		return
+	}
+	rf.general[id] = value
+	rf.written |= 1 << id
+}
+
+// readHILO returns HI and LO as a 64-bit accumulator
+func (rf *registerFile) readHILO() uint64 {
+	return uint64(rf.HI)<<32 | uint64(rf.LO)
+}
+
+func (rf *registerFile) writeHILO(value uint64) {
+	rf.HI, rf.LO = uint32(value>>32), uint32(value)
+}
+
+// clearLink clears the link bit of the cores linked to the word at addr
+func (m *Machine) clearLink(addr uint32) {
+	for _, c := range m.cores {
+		if c.llBit && addr&^3 == c.llAddr {
+			c.llBit = false
 		}
-		return actual, stackSegment, nil
-	default:
-		return 0, 0, errors.New("Segmentfault")
 	}
 }
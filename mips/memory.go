@@ -0,0 +1,431 @@
+package mips
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	pageShift = 12
+	pageSize  = 1 << pageShift
+)
+
+type addrSeg int
+
+const (
+	textSegment addrSeg = 1 << iota
+	dataSegment
+	stackSegment
+	heapSegment
+	ktextSegment
+	kdataSegment
+)
+
+/*
+   +-----------+   MAX_KDATA_ADDR
+   |   kdata   |   kernel only
+   +-----------+   KDATA_ADDRESS
+   |   ktext   |   kernel only
+   +-----------+   KTEXT_ADDRESS
+   |  unmaped  |
+   +-----------+   StackBase
+   |   stack   |
+   |    ...    |   grows down to StackLimit
+   |           |
+   |    ...    |   grows up to DataLimit
+   +-----------+   break
+   |   heap    |
+   +-----------+
+   |   data    |
+   +-----------+   DataBase
+   |   text    |
+   +-----------+   Text
+*/
+
+// Perm is a set of access permissions of memory
+type Perm uint8
+
+const (
+	PermRead Perm = 1 << iota
+	PermWrite
+	PermExec
+)
+
+// page is a page of memory, allocated when it is first written
+type page struct {
+	data [pageSize]byte
+	// decoded caches resolved instructions, one entry per word. It is
+	// allocated when the page is first executed, and writing to a word
+	// invalidates its entry.
+	decoded []*execInst
+}
+
+// MemoryStats reports the memory allocated for a program
+type MemoryStats struct {
+	Pages     int // pages allocated
+	Bytes     int // bytes of the pages allocated
+	PeakPages int // largest number of pages allocated at once
+}
+
+// virtualMemory is a sparse memory of 4 KB pages covering the 32-bit
+// address space. Segments are mapped as the layout tells; pages of
+// them are allocated when they are first written, and read as zero
+// until then. Pages may also be mapped and unmapped explicitly.
+type virtualMemory struct {
+	pages map[uint32]*page // by page number
+	// permissions of pages mapped explicitly, by page number, which
+	// override the segments. Unmapped pages have no permissions.
+	maps map[uint32]Perm
+	// the last page looked up, which most accesses hit again
+	lastNum   uint32
+	last      *page
+	peakPages int
+	pageLimit int // pages that may be allocated, if not zero
+
+	layout MemoryLayout
+	// The heap follows static data from heapStart up to the break brk,
+	// which sbrk moves up to heapStart+heapLimit, if heapLimit is not
+	// zero, and to the end of the data segment. Until a program is
+	// loaded, there is no heap and data fills the data segment.
+	heapStart, brk uint32
+	heapLimit      uint32
+	// permissions of segments. Only the loaded part of text and ktext,
+	// up to textEnd and ktextEnd, is executable.
+	perms             map[addrSeg]Perm
+	textEnd, ktextEnd uint32
+	// order is the byte order of halfwords and words
+	order binary.ByteOrder
+	// devices mapped over memory, and the error of mapping one by an
+	// option, reported by Load
+	devices []*mappedDevice
+	mapErr  error
+	// displays showing memory, which tick like devices
+	displays []*Display
+}
+
+func newVirtualMemory() *virtualMemory {
+	m := &virtualMemory{
+		pages: make(map[uint32]*page),
+		maps:  make(map[uint32]Perm),
+		perms: map[addrSeg]Perm{
+			textSegment:  PermRead | PermExec,
+			dataSegment:  PermRead | PermWrite,
+			heapSegment:  PermRead | PermWrite,
+			stackSegment: PermRead | PermWrite,
+			ktextSegment: PermRead | PermExec,
+			kdataSegment: PermRead | PermWrite,
+		},
+		ktextEnd: KTEXT_ADDRESS,
+		order:    binary.LittleEndian,
+	}
+	m.setLayout(DefaultLayout)
+	return m
+}
+
+// setLayout places the segments as l tells, before anything is loaded
+func (m *virtualMemory) setLayout(l MemoryLayout) {
+	m.layout = l
+	m.heapStart, m.brk = l.DataLimit, l.DataLimit
+	m.textEnd = l.Text
+}
+
+// setHeap places an empty heap at start, which ends the data segment
+func (m *virtualMemory) setHeap(start uint32) {
+	m.heapStart, m.brk = start, start
+}
+
+// sbrk moves the break up by n bytes, rounded up to a word, and returns
+// the old break, which is the start of the new space.
+func (m *virtualMemory) sbrk(n uint32) (uint32, error) {
+	brk := m.brk
+	end := uint64(brk) + uint64(n+3)&^3
+	if m.heapLimit != 0 && end > uint64(m.heapStart)+uint64(m.heapLimit) ||
+		end > uint64(m.layout.DataLimit) {
+		return 0, errors.New("sbrk: out of heap memory")
+	}
+	m.brk = uint32(end)
+	return brk, nil
+}
+
+// segment returns the segment containing addr, or 0 if it is not mapped
+func (m *virtualMemory) segment(addr uint32) addrSeg {
+	switch {
+	case addr >= m.layout.Text && addr < m.layout.DataBase:
+		return textSegment
+	case addr >= m.layout.DataBase && addr < m.heapStart:
+		return dataSegment
+	case addr >= m.heapStart && addr < m.brk:
+		return heapSegment
+	case addr >= m.layout.StackLimit && addr < m.layout.StackBase+4:
+		return stackSegment
+	case addr >= KTEXT_ADDRESS && addr < KDATA_ADDRESS:
+		return ktextSegment
+	case addr >= KDATA_ADDRESS && addr < MAX_KDATA_ADDR:
+		return kdataSegment
+	}
+	return 0
+}
+
+// perm returns the permissions of addr, which are zero if it is not
+// mapped
+func (m *virtualMemory) perm(addr uint32) Perm {
+	if m.device(addr) != nil {
+		return PermRead | PermWrite
+	}
+	if p, ok := m.maps[addr>>pageShift]; ok {
+		return p
+	}
+	return m.perms[m.segment(addr)]
+}
+
+// executable reports whether the word at addr is code that may be
+// executed
+func (m *virtualMemory) executable(addr uint32) bool {
+	if m.device(addr) != nil {
+		return false
+	}
+	if p, ok := m.maps[addr>>pageShift]; ok {
+		return p&PermExec != 0
+	}
+	seg := m.segment(addr)
+	switch {
+	case m.perms[seg]&PermExec == 0:
+		return false
+	case seg == textSegment:
+		return addr < m.textEnd
+	case seg == ktextSegment:
+		return addr < m.ktextEnd
+	}
+	return true
+}
+
+// mapPages sets the permissions of the pages in [addr, addr+size), which
+// must be aligned to pages. Unmapping pages, with no permissions, frees
+// them.
+func (m *virtualMemory) mapPages(addr, size uint32, perm Perm) error {
+	if addr&(pageSize-1) != 0 || size&(pageSize-1) != 0 {
+		return fmt.Errorf("map %#x: address and size must be aligned to %d bytes",
+			addr, pageSize)
+	}
+	first := uint64(addr) >> pageShift
+	end := first + uint64(size)>>pageShift
+	if end > 1<<(32-pageShift) {
+		return fmt.Errorf("map %#x: region exceeds the address space", addr)
+	}
+	for n := uint32(first); uint64(n) < end; n++ {
+		m.maps[n] = perm
+		if perm == 0 {
+			delete(m.pages, n)
+		} else if p := m.pages[n]; p != nil {
+			// permissions to execute may have changed
+			p.decoded = nil
+		}
+	}
+	m.last = nil
+	return nil
+}
+
+func (m *virtualMemory) stats() MemoryStats {
+	return MemoryStats{
+		Pages:     len(m.pages),
+		Bytes:     len(m.pages) * pageSize,
+		PeakPages: m.peakPages,
+	}
+}
+
+// lookup returns page n, allocating it if alloc is set. It returns nil
+// if the page is not allocated and alloc is not set, or allocating it
+// would exceed pageLimit.
+func (m *virtualMemory) lookup(n uint32, alloc bool) *page {
+	if m.last != nil && m.lastNum == n {
+		return m.last
+	}
+	p := m.pages[n]
+	if p == nil {
+		if !alloc || m.pageLimit != 0 && len(m.pages) >= m.pageLimit {
+			return nil
+		}
+		p = new(page)
+		m.pages[n] = p
+		if len(m.pages) > m.peakPages {
+			m.peakPages = len(m.pages)
+		}
+	}
+	m.lastNum, m.last = n, p
+	return p
+}
+
+func (m *virtualMemory) check(addr uint32) error {
+	if m.perm(addr) == 0 {
+		return fmt.Errorf("address %#x is not mapped", addr)
+	}
+	return nil
+}
+
+func (m *virtualMemory) read(addr uint32) (byte, error) {
+	if d := m.device(addr); d != nil {
+		return byte(m.readDevice(d, addr, 1)), nil
+	}
+	if err := m.check(addr); err != nil {
+		return 0, err
+	}
+	p := m.lookup(addr>>pageShift, false)
+	if p == nil {
+		return 0, nil
+	}
+	return p.data[addr&(pageSize-1)], nil
+}
+
+func (m *virtualMemory) write(addr uint32, value byte) error {
+	if d := m.device(addr); d != nil {
+		m.writeDevice(d, addr, 1, uint32(value))
+		return nil
+	}
+	if err := m.check(addr); err != nil {
+		return err
+	}
+	p := m.lookup(addr>>pageShift, true)
+	if p == nil {
+		return &LimitError{LimitMemory}
+	}
+	off := addr & (pageSize - 1)
+	p.data[off] = value
+	if p.decoded != nil {
+		p.decoded[off>>2] = nil
+	}
+	return nil
+}
+
+func (m *virtualMemory) readHalf(addr uint32) (uint16, error) {
+	if d := m.device(addr); d != nil && addr&1 == 0 {
+		return uint16(m.readDevice(d, addr, 2)), nil
+	}
+	var b [2]byte
+	for i := range b {
+		var err error
+		if b[i], err = m.read(addr + uint32(i)); err != nil {
+			return 0, err
+		}
+	}
+	return m.order.Uint16(b[:]), nil
+}
+
+func (m *virtualMemory) writeHalf(addr uint32, value uint16) error {
+	if d := m.device(addr); d != nil && addr&1 == 0 {
+		m.writeDevice(d, addr, 2, uint32(value))
+		return nil
+	}
+	var b [2]byte
+	m.order.PutUint16(b[:], value)
+	return m.writeBytes(addr, b[:])
+}
+
+// readWord reads the word at addr. Aligned words lie in a single page
+// and segment, and are read at once.
+func (m *virtualMemory) readWord(addr uint32) (uint32, error) {
+	if addr&3 != 0 {
+		var b [4]byte
+		for i := range b {
+			var err error
+			if b[i], err = m.read(addr + uint32(i)); err != nil {
+				return 0, err
+			}
+		}
+		return m.order.Uint32(b[:]), nil
+	}
+	if d := m.device(addr); d != nil {
+		return m.readDevice(d, addr, 4), nil
+	}
+	if err := m.check(addr); err != nil {
+		return 0, err
+	}
+	p := m.lookup(addr>>pageShift, false)
+	if p == nil {
+		return 0, nil
+	}
+	return m.order.Uint32(p.data[addr&(pageSize-1):]), nil
+}
+
+// peekWord reads the aligned word at addr from the pages, without
+// reading devices. Pages not allocated read as zero.
+func (m *virtualMemory) peekWord(addr uint32) uint32 {
+	p := m.pages[addr>>pageShift]
+	if p == nil {
+		return 0
+	}
+	return m.order.Uint32(p.data[addr&(pageSize-1):])
+}
+
+func (m *virtualMemory) writeWord(addr uint32, value uint32) error {
+	if addr&3 != 0 {
+		var b [4]byte
+		m.order.PutUint32(b[:], value)
+		return m.writeBytes(addr, b[:])
+	}
+	if d := m.device(addr); d != nil {
+		m.writeDevice(d, addr, 4, value)
+		return nil
+	}
+	if err := m.check(addr); err != nil {
+		return err
+	}
+	p := m.lookup(addr>>pageShift, true)
+	if p == nil {
+		return &LimitError{LimitMemory}
+	}
+	off := addr & (pageSize - 1)
+	m.order.PutUint32(p.data[off:], value)
+	if p.decoded != nil {
+		p.decoded[off>>2] = nil
+	}
+	return nil
+}
+
+// bigEndian reports whether the most significant byte of a word is at
+// its lowest address
+func (m *virtualMemory) bigEndian() bool {
+	return m.order == binary.BigEndian
+}
+
+// byteLane returns the position of the byte at addr in its word, counted
+// in bytes from the least significant end
+func (m *virtualMemory) byteLane(addr uint32) uint32 {
+	if m.bigEndian() {
+		return 3 - addr&3
+	}
+	return addr & 3
+}
+
+func (m *virtualMemory) writeBytes(addr uint32, s []byte) error {
+	for i := 0; i < len(s); i++ {
+		err := m.write(addr+uint32(i), s[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedInst returns the decoded instruction at addr, or nil if it is
+// not cached.
+func (m *virtualMemory) cachedInst(addr uint32) *execInst {
+	p := m.lookup(addr>>pageShift, false)
+	if addr&3 != 0 || p == nil || p.decoded == nil {
+		return nil
+	}
+	return p.decoded[addr&(pageSize-1)>>2]
+}
+
+// cacheInst remembers the decoded instruction at addr, which has been
+// checked to be executable.
+func (m *virtualMemory) cacheInst(addr uint32, inst *execInst) {
+	p := m.lookup(addr>>pageShift, true)
+	if p == nil {
+		return
+	}
+	if p.decoded == nil {
+		p.decoded = make([]*execInst, pageSize>>2)
+	}
+	p.decoded[addr&(pageSize-1)>>2] = inst
+}
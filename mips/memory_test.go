@@ -0,0 +1,126 @@
+package mips
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSparseMemory(t *testing.T) {
+	m := newVirtualMemory()
+	if v, err := m.readWord(0x10000000); err != nil || v != 0 {
+		t.Errorf("expect a zero word, got %#x, %v", v, err)
+	}
+	if s := m.stats(); s.Pages != 0 {
+		t.Errorf("expect reads not to allocate pages, got %d", s.Pages)
+	}
+	for _, addr := range []uint32{0x10000000, 0x10000ffe, DATA_ADDRESS + 1} {
+		if err := m.writeWord(addr, 0x12345678); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := m.readWord(addr); err != nil || v != 0x12345678 {
+			t.Errorf("%#x: expect 0x12345678, got %#x, %v", addr, v, err)
+		}
+	}
+	if b, _ := m.read(0x10000ffe); b != 0x78 {
+		t.Errorf("expect little-endian bytes, got %#x", b)
+	}
+	// the word at 0x10000ffe spans two pages
+	if s := m.stats(); s.Pages != 3 || s.Bytes != 3*pageSize || s.PeakPages != 3 {
+		t.Errorf("expect 3 pages, got %+v", s)
+	}
+	if _, err := m.readWord(0x7F001000); err == nil {
+		t.Error("expect an error reading an unmapped address")
+	}
+}
+
+func TestMapPages(t *testing.T) {
+	m := newVirtualMemory()
+	if err := m.mapPages(0x7F001001, pageSize, PermRead); err == nil {
+		t.Error("expect an unaligned region to be refused")
+	}
+	if err := m.mapPages(0xFFFFF000, 2*pageSize, PermRead); err == nil {
+		t.Error("expect a region beyond the address space to be refused")
+	}
+	if err := m.mapPages(0x7F001000, 2*pageSize, PermRead|PermWrite); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.writeWord(0x7F002ffc, 1); err != nil {
+		t.Error(err)
+	}
+	if err := m.mapPages(0x7F002000, pageSize, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.readWord(0x7F002ffc); err == nil {
+		t.Error("expect an error reading an unmapped page")
+	}
+	if s := m.stats(); s.Pages != 0 || s.PeakPages != 1 {
+		t.Errorf("expect the page to be freed, got %+v", s)
+	}
+}
+
+func TestMapEmulator(t *testing.T) {
+	src := `.text
+main:
+	li $t0, 0x7F001000
+	li $t1, 42
+	sw $t1, 0($t0)
+	lw $t2, 0($t0)
+	li $v0, 10
+	syscall`
+	em := NewEmulator()
+	if err := em.Load(assembleString(t, src)); err != nil {
+		t.Fatal(err)
+	}
+	if err := em.Map(0x7F001000, pageSize, PermRead); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := em.Run(context.Background()); err == nil {
+		t.Error("expect storing to a read-only page to fail")
+	}
+
+	em = NewEmulator()
+	if err := em.Load(assembleString(t, src)); err != nil {
+		t.Fatal(err)
+	}
+	em.Map(0x7F001000, pageSize, PermRead|PermWrite)
+	if _, err := em.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := em.ReadReg("t2"); v != 42 {
+		t.Errorf("expect $t2 = 42, got %d", v)
+	}
+	// the page of text and the page mapped, as the stack is not touched
+	if s := em.MemoryStats(); s.Pages != 2 {
+		t.Errorf("expect 2 pages, got %+v", s)
+	}
+
+	// unmapping the stack stops it from growing
+	em = NewEmulator()
+	if err := em.Load(assembleString(t, ".text\nmain:\n\tsw $zero, -4($sp)")); err != nil {
+		t.Fatal(err)
+	}
+	em.Unmap(STACK_ADDRESS-pageSize, pageSize)
+	if _, err := em.Run(context.Background()); err == nil {
+		t.Error("expect storing to an unmapped page to fail")
+	}
+}
+
+func TestDeepStack(t *testing.T) {
+	// push 8 MB onto the stack, beyond where it used to end
+	em := NewEmulator()
+	if err := em.LoadAndRun(assembleString(t, `.text
+main:
+	li $t0, 0x200000
+loop:
+	addi $sp, $sp, -4
+	sw $t0, 0($sp)
+	addi $t0, $t0, -1
+	bgtz $t0, loop
+	li $v0, 10
+	syscall`)); err != nil {
+		t.Fatal(err)
+	}
+	if s := em.MemoryStats(); s.Pages != 1+(8<<20)/pageSize {
+		t.Errorf("expect a page of text and 8 MB of stack, got %+v", s)
+	}
+}
@@ -27,8 +27,11 @@ type parseItem struct {
 	directive   string
 	data        interface{} // args of directive
 	imme        int         // immediate constant
+	imme2       int         // second immediate constant, e.g. size of ext
+	float       float64     // floating-point constant
 	label       string
 	address     int
+	fillSlot    bool // followed by a nop in its delay slot
 	line        int
 	err         string
 }
@@ -40,12 +43,15 @@ type parser struct {
 	itemList  *list.List
 	entryAddr int
 	line      int
+	layout    MemoryLayout
 }
 
 type parseFn func(*parser) parseFn
 
-func parse(r *bufio.Reader) <-chan parseItem {
+// parse parses assembly, placing labels as layout tells
+func parse(r *bufio.Reader, layout MemoryLayout) <-chan parseItem {
 	p := &parser{
+		layout:   layout,
 		items:    make(chan parseItem),
 		tokens:   lex(r),
 		itemList: list.New(),
@@ -127,11 +133,21 @@ func (p *parser) parseInst(inst string) parseFn {
 
 	args := instructionTable[inst].syntax
 	for i, s := range args {
-		switch s {
-		case argReg:
+		if s&argOptional != 0 {
+			// The line may end where an optional argument starts
+			expectTokens <- []tokenType{tokenComma, tokenEndline, tokenEOF}
+		} else if i > 0 {
+			expectTokens <- []tokenType{tokenComma}
+		}
+		switch s &^ argOptional {
+		case argReg, argCReg:
 			expectTokens <- []tokenType{tokenRegister}
 		case argInteger:
 			expectTokens <- []tokenType{tokenInteger}
+		case argFReg:
+			expectTokens <- []tokenType{tokenFPRegister}
+		case argFloat:
+			expectTokens <- []tokenType{tokenFloat, tokenInteger}
 		case argLabel:
 			expectTokens <- []tokenType{tokenLabel}
 		case argInteger | argLabel:
@@ -144,9 +160,6 @@ func (p *parser) parseInst(inst string) parseFn {
 		default:
 			// shouldn't get here
 		}
-		if i < len(args)-1 {
-			expectTokens <- []tokenType{tokenComma}
-		}
 	}
 	close(expectTokens)
 	return <-ret
@@ -158,6 +171,7 @@ func (p *parser) parseArgs(inst string, expectTokens <-chan []tokenType, ret cha
 		typ:         itemInst,
 		line:        p.line,
 	}
+	integers := 0
 	for types := range expectTokens {
 		token := <-p.tokens
 		matched := false
@@ -172,17 +186,51 @@ func (p *parser) parseArgs(inst string, expectTokens <-chan []tokenType, ret cha
 				if strings.HasPrefix(token.val, "0X") {
 					token.val = strings.ToLower(token.val)
 				}
-				i, err := strconv.ParseInt(token.val, 0, 32)
+				// Accept both signed and unsigned 32-bit integers
+				i, err := strconv.ParseInt(token.val, 0, 64)
+				if err == nil && (i < -1<<31 || i >= 1<<32) {
+					err = strconv.ErrRange
+				}
 				if err != nil {
 					ret <- p.errorf("failed to parse integer %q: %s",
 						token.val, err.Error())
 					return
 				}
-				item.imme = int(i)
-			case tokenRegister:
+				if integers == 0 {
+					item.imme = int(i)
+				} else {
+					item.imme2 = int(i)
+				}
+				integers++
+				// Integers are also accepted as floating-point constants
+				item.float = float64(i)
+			case tokenFloat:
+				f, err := strconv.ParseFloat(token.val, 64)
+				if err != nil {
+					ret <- p.errorf("failed to parse float %q: %s",
+						token.val, err.Error())
+					return
+				}
+				item.float = f
+			case tokenRegister, tokenFPRegister:
 				item.registers = append(item.registers, token.val)
 			case tokenLabel:
 				item.label = token.val
+			case tokenEndline, tokenEOF:
+				// Optional arguments are omitted
+				for range expectTokens {
+				}
+				p.items <- shortForm(item)
+				if token.typ == tokenEOF {
+					p.items <- parseItem{
+						typ: itemEOF,
+					}
+					ret <- nil
+					return
+				}
+				p.line++
+				ret <- parseStart
+				return
 			default:
 				// Skip
 			}
@@ -203,6 +251,16 @@ func (p *parser) parseArgs(inst string, expectTokens <-chan []tokenType, ret cha
 	ret <- parseEndline
 }
 
+// shortForm completes an instruction whose optional arguments are
+// omitted.
+func shortForm(item parseItem) parseItem {
+	switch item.instruction {
+	case "jalr":
+		item.registers = []string{"$ra", item.registers[0]}
+	}
+	return item
+}
+
 func (p *parser) parseDir(dir string) parseFn {
 	item := parseItem{
 		typ:       itemDir,
@@ -248,15 +306,49 @@ func (p *parser) parseDir(dir string) parseFn {
 			}
 		}
 		item.data = data
+	case "float", "double":
+		var data []float64
+	FLOAT_LOOP:
+		for {
+			t = <-p.tokens
+			switch t.typ {
+			case tokenFloat:
+				f, err := strconv.ParseFloat(t.val, 64)
+				if err != nil {
+					return p.errorf("parse %q: %s", t.val, err.Error())
+				}
+				data = append(data, f)
+			case tokenInteger:
+				i, err := strconv.ParseInt(t.val, 0, 64)
+				if err != nil {
+					return p.errorf("parse %q: %s", t.val, err.Error())
+				}
+				data = append(data, float64(i))
+			default:
+				return p.errorf("unexpected token %q(type %q), expect %q",
+					t.val, t.typ, "tokenFloat | tokenInteger")
+			}
+			t = <-p.tokens
+			switch t.typ {
+			case tokenComma:
+				continue
+			case tokenEndline, tokenEOF:
+				break FLOAT_LOOP
+			default:
+				return p.errorf("unexpected token %q(type %q)",
+					t.val, t.typ)
+			}
+		}
+		item.data = data
 	case "align", "space":
 		t = <-p.tokens
 		switch t.typ {
 		case tokenInteger:
-			i, err := strconv.ParseInt(t.val, 0, 8)
+			i, err := strconv.ParseUint(t.val, 0, 31)
 			if err != nil {
 				return p.errorf("parse %q: %s", t.val, err.Error())
 			}
-			item.data = i
+			item.data = int(i)
 		default:
 			return p.errorf("unexpected token %q(type %q), expect %q",
 				t.val, t.typ, tokenInteger)
@@ -281,6 +373,31 @@ func (p *parser) parseDir(dir string) parseFn {
 		}
 	case "data", "text":
 		// Do nothing
+	case "set":
+		// Assembler option, e.g. reorder or noreorder
+		t = <-p.tokens
+		switch t.typ {
+		case tokenLabel:
+			item.data = t.val
+		default:
+			return p.errorf("unexpected token %q(type %q), expect %q",
+				t.val, t.typ, tokenLabel)
+		}
+	case "kdata", "ktext":
+		// Optional address to continue at
+		t = <-p.tokens
+		switch t.typ {
+		case tokenInteger:
+			i, err := strconv.ParseUint(t.val, 0, 32)
+			if err != nil {
+				return p.errorf("parse %q: %s", t.val, err.Error())
+			}
+			item.data = int(i)
+		case tokenEndline, tokenEOF:
+		default:
+			return p.errorf("unexpected token %q(type %q), expect %q",
+				t.val, t.typ, tokenInteger)
+		}
 	default:
 		return p.errorf("invalid directive %q", t.val)
 	}
@@ -93,7 +93,7 @@ Next:
 )
 
 func TestParse(t *testing.T) {
-	ch := parse(bufio.NewReader(strings.NewReader(inputP1)))
+	ch := parse(bufio.NewReader(strings.NewReader(inputP1)), DefaultLayout)
 	for item := range ch {
 		if item.typ == itemError {
 			log.Println(item.err)
@@ -1,6 +1,9 @@
 package mips
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+)
 
 func (p *parser) pseudoFilter(items <-chan parseItem) <-chan parseItem {
 	result := make(chan parseItem)
@@ -13,6 +16,9 @@ func (p *parser) pseudoFilter(items <-chan parseItem) <-chan parseItem {
 				} else {
 					result <- item
 				}
+				if item.fillSlot {
+					result <- nopItem()
+				}
 			default:
 				result <- item
 			}
@@ -22,8 +28,40 @@ func (p *parser) pseudoFilter(items <-chan parseItem) <-chan parseItem {
 	return result
 }
 
+// loadFloat loads bits to floating-point register reg through $at
+func loadFloat(reg string, bits uint32, result chan<- parseItem) {
+	result <- parseItem{
+		typ:         itemInst,
+		instruction: "lui",
+		registers:   []string{"$at"},
+		imme:        int(bits >> 16),
+	}
+	result <- parseItem{
+		typ:         itemInst,
+		instruction: "ori",
+		registers:   []string{"$at", "$at"},
+		imme:        int(bits & 0xFFFF),
+	}
+	result <- parseItem{
+		typ:         itemInst,
+		instruction: "mtc1",
+		registers:   []string{"$at", reg},
+	}
+}
+
+// nopItem returns "sll $zero, $zero, 0", which encodes as word 0
+func nopItem() parseItem {
+	return parseItem{
+		typ:         itemInst,
+		instruction: "sll",
+		registers:   []string{"$zero", "$zero"},
+	}
+}
+
 func (p *parser) translate(i parseItem, result chan<- parseItem) {
 	switch i.instruction {
+	case "nop":
+		result <- nopItem()
 	case "move":
 		result <- parseItem{
 			typ:         itemInst,
@@ -129,37 +167,54 @@ func (p *parser) translate(i parseItem, result chan<- parseItem) {
 			registers:   []string{"$at", "$zero"},
 			imme:        i.imme - 1,
 		}
-	case "bgtz":
+	case "beqz":
 		result <- parseItem{
 			typ:         itemInst,
-			instruction: "slt",
-			registers:   []string{"$at", "$zero", i.registers[0]},
+			instruction: "beq",
+			registers:   []string{"$zero", i.registers[0]},
+			imme:        i.imme,
 		}
+	case "bnez":
 		result <- parseItem{
 			typ:         itemInst,
 			instruction: "bne",
-			registers:   []string{"$at", "$zero"},
-			imme:        i.imme - 1,
-		}
-	case "beqz":
-		result <- parseItem{
-			typ:         itemInst,
-			instruction: "beq",
 			registers:   []string{"$zero", i.registers[0]},
 			imme:        i.imme,
 		}
-	case "mul":
+	case "b":
 		result <- parseItem{
 			typ:         itemInst,
-			instruction: "mult",
-			registers:   []string{i.registers[1], i.registers[2]},
+			instruction: "beq",
+			registers:   []string{"$zero", "$zero"},
+			imme:        i.imme,
 		}
+	case "bal":
 		result <- parseItem{
 			typ:         itemInst,
-			instruction: "mflo",
-			registers:   []string{i.registers[0]},
+			instruction: "bgezal",
+			registers:   []string{"$zero"},
 			imme:        i.imme,
 		}
+	case "l.s", "s.s", "l.d", "s.d":
+		i.instruction = map[string]string{
+			"l.s": "lwc1", "s.s": "swc1", "l.d": "ldc1", "s.d": "sdc1",
+		}[i.instruction]
+		result <- i
+	case "li.s":
+		loadFloat(i.registers[0], math.Float32bits(float32(i.float)), result)
+	case "li.d":
+		n := fpRegisterTable[i.registers[0][1:]]
+		if n&1 != 0 {
+			result <- parseItem{
+				typ: itemError,
+				err: fmt.Sprintf("line %d: %s needs an even register, got %s",
+					i.line+1, i.instruction, i.registers[0]),
+			}
+			return
+		}
+		b := math.Float64bits(i.float)
+		loadFloat(i.registers[0], uint32(b), result)
+		loadFloat(fmt.Sprintf("$f%d", n+1), uint32(b>>32), result)
 	case "divq":
 		result <- parseItem{
 			typ:         itemInst,
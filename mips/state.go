@@ -76,9 +76,10 @@ func lexEndline(l *lexer) stateFn {
 
 // lexIdentifier lexes instructions and labels
 // Any identifier not in instruction set is treated as label
+// Identifiers may contain dots after the first letter, as in "add.s"
 func lexIdentifier(l *lexer) stateFn {
 	var r rune
-	for r = l.next(); isLetterDigit(r); r = l.next() {
+	for r = l.next(); isLetterDigit(r) || r == '.'; r = l.next() {
 	}
 	l.backup()
 	switch {
@@ -99,7 +100,8 @@ func lexIdentifier(l *lexer) stateFn {
 	return lexInline
 }
 
-// lexNumber lexes numbers in decimal or hex format
+// lexNumber lexes numbers in decimal or hex format, and decimal
+// floating-point numbers
 func lexNumber(l *lexer) stateFn {
 	// Optional leading sign.
 	l.accept("+-")
@@ -107,13 +109,26 @@ func lexNumber(l *lexer) stateFn {
 	digits := "0123456789"
 	if l.accept("0") && l.accept("xX") {
 		digits = "0123456789abcdefABCDEF"
+		l.acceptRun(digits)
+		l.emit(tokenInteger)
+		return lexInline
 	}
 	l.acceptRun(digits)
-	l.emit(tokenInteger)
+	typ := tokenInteger
+	if l.accept(".") {
+		l.acceptRun(digits)
+		typ = tokenFloat
+	}
+	if l.accept("eE") {
+		l.accept("+-")
+		l.acceptRun(digits)
+		typ = tokenFloat
+	}
+	l.emit(typ)
 	return lexInline
 }
 
-// lexRegister lexes 32 mips registers
+// lexRegister lexes 32 mips registers and 32 floating-point registers
 func lexRegister(l *lexer) stateFn {
 	r := l.next() // '$'
 	for r = l.next(); isLetterDigit(r); r = l.next() {
@@ -123,6 +138,10 @@ func lexRegister(l *lexer) stateFn {
 		l.emit(tokenRegister)
 		return lexInline
 	}
+	if _, ok := fpRegisterTable[l.curValue()[1:]]; ok {
+		l.emit(tokenFPRegister)
+		return lexInline
+	}
 	return l.errorf("invalid register name: %q", l.curValue())
 }
 
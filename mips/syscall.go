@@ -0,0 +1,338 @@
+package mips
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registers used by system calls
+const (
+	regV0 = 2
+	regA0 = 4
+	regA1 = 5
+	regA2 = 6
+)
+
+// flags of the open system call, as in MARS
+const (
+	openRead   = 0
+	openWrite  = 1
+	openAppend = 9
+)
+
+// ioChunk is the most bytes the read and write system calls copy at
+// once, whatever length the program asks for
+const ioChunk = 4096
+
+// syscallTable maps service numbers in $v0 to their implementation.
+// Numbers follow SPIM and MARS.
+var syscallTable = map[uint32]func(m *Machine){
+	1: func(m *Machine) { // print integer
+		m.printf("%d", int32(m.r.read(regA0)))
+	},
+	2: func(m *Machine) { // print float in $f12
+		m.printf("%v", float32(m.readFP(cop1S, 12)))
+	},
+	3: func(m *Machine) { // print double in $f12
+		m.printf("%v", m.readFP(cop1D, 12))
+	},
+	4: func(m *Machine) { // print null-terminated string
+		m.printf("%s", m.loadString(m.r.read(regA0)))
+	},
+	5: func(m *Machine) { // read integer
+		i, err := strconv.ParseInt(m.readLine(), 10, 32)
+		checkInstErr(err)
+		m.r.write(regV0, uint32(i))
+	},
+	6: func(m *Machine) { // read float to $f0
+		f, err := strconv.ParseFloat(m.readLine(), 32)
+		checkInstErr(err)
+		m.writeFP(cop1S, 0, f)
+	},
+	7: func(m *Machine) { // read double to $f0
+		f, err := strconv.ParseFloat(m.readLine(), 64)
+		checkInstErr(err)
+		m.writeFP(cop1D, 0, f)
+	},
+	8: func(m *Machine) { // read string into a buffer of $a1 bytes, like fgets
+		addr := m.r.read(regA0)
+		n := int32(m.r.read(regA1))
+		if n < 1 {
+			return
+		}
+		for ; n > 1; n-- {
+			b, err := m.stdin.ReadByte()
+			if err == io.EOF {
+				break
+			}
+			checkInstErr(err)
+			m.limit.read(1)
+			m.storeByte(addr, b)
+			addr++
+			if b == '\n' {
+				break
+			}
+		}
+		m.storeByte(addr, 0)
+	},
+	9: func(m *Machine) { // sbrk
+		n := int32(m.r.read(regA0))
+		if n < 0 {
+			panic(errors.New("sbrk: negative amount"))
+		}
+		brk, err := m.m.sbrk(uint32(n))
+		checkInstErr(err)
+		m.r.write(regV0, brk)
+	},
+	10: func(m *Machine) { // exit
+		m.exit = true
+	},
+	11: func(m *Machine) { // print character
+		m.printf("%c", byte(m.r.read(regA0)))
+	},
+	12: func(m *Machine) { // read character
+		b, err := m.stdin.ReadByte()
+		checkInstErr(err)
+		m.limit.read(1)
+		m.r.write(regV0, uint32(b))
+	},
+	13: func(m *Machine) { // open file
+		m.r.write(regV0, uint32(m.openFile(m.loadString(m.r.read(regA0)), m.r.read(regA1))))
+	},
+	14: func(m *Machine) { // read from file
+		fd := m.r.read(regA0)
+		f, ok := m.files[fd]
+		n := int32(m.r.read(regA2))
+		var r io.Reader = f
+		if fd == 0 {
+			r, ok = &limitReader{m.stdin, m.limit}, true
+		}
+		if !ok || n < 0 {
+			m.r.write(regV0, ^uint32(0))
+			return
+		}
+		// Read in chunks until a short read. Return zero at end of file,
+		// -1 on error.
+		var buf [ioChunk]byte
+		addr := m.r.read(regA1)
+		k := 0
+		for k < int(n) {
+			if k > 0 {
+				m.limit.chunk()
+			}
+			want := min(int(n)-k, ioChunk)
+			c, err := r.Read(buf[:want])
+			for i := 0; i < c; i++ {
+				m.storeByte(addr+uint32(k+i), buf[i])
+			}
+			k += c
+			if err != nil && err != io.EOF {
+				k = -1
+			}
+			if err != nil || c < want {
+				break
+			}
+		}
+		m.r.write(regV0, uint32(k))
+	},
+	15: func(m *Machine) { // write to file
+		fd := m.r.read(regA0)
+		f, ok := m.files[fd]
+		n := int32(m.r.read(regA2))
+		var w io.Writer = f
+		switch fd {
+		case 1:
+			w, ok = m.stdout, true
+		case 2:
+			w, ok = m.stderr, true
+		default:
+			// files count as output too
+			w = &limitWriter{f, m.limit}
+		}
+		if !ok || n < 0 {
+			m.r.write(regV0, ^uint32(0))
+			return
+		}
+		var buf [ioChunk]byte
+		addr := m.r.read(regA1)
+		k := 0
+		for k < int(n) {
+			if k > 0 {
+				m.limit.chunk()
+			}
+			c := min(int(n)-k, ioChunk)
+			for i := 0; i < c; i++ {
+				buf[i] = m.loadByte(addr + uint32(k+i))
+			}
+			if _, err := w.Write(buf[:c]); err != nil {
+				k = -1
+				break
+			}
+			k += c
+		}
+		m.r.write(regV0, uint32(k))
+	},
+	16: func(m *Machine) { // close file
+		fd := m.r.read(regA0)
+		if f, ok := m.files[fd]; ok {
+			f.Close()
+			delete(m.files, fd)
+		}
+	},
+	17: func(m *Machine) { // exit with code in $a0
+		m.exit = true
+		m.exitCode = int(int32(m.r.read(regA0)))
+	},
+	30: func(m *Machine) { // system time in milliseconds, low word in $a0
+		ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+		m.r.write(regA0, uint32(ms))
+		m.r.write(regA1, uint32(ms>>32))
+	},
+	32: func(m *Machine) { // sleep $a0 milliseconds
+		m.sleep = time.Duration(int32(m.r.read(regA0))) * time.Millisecond
+	},
+	34: func(m *Machine) { // print integer in hexadecimal
+		m.printf("0x%08x", m.r.read(regA0))
+	},
+	35: func(m *Machine) { // print integer in binary
+		m.printf("%032b", m.r.read(regA0))
+	},
+	36: func(m *Machine) { // print integer as unsigned
+		m.printf("%d", m.r.read(regA0))
+	},
+	40: func(m *Machine) { // set seed $a1 of generator $a0
+		m.rands[m.r.read(regA0)] = rand.New(rand.NewSource(int64(m.r.read(regA1))))
+	},
+	41: func(m *Machine) { // random integer to $a0
+		m.r.write(regA0, m.random(m.r.read(regA0)).Uint32())
+	},
+	42: func(m *Machine) { // random integer in [0, $a1) to $a0
+		bound := int32(m.r.read(regA1))
+		if bound <= 0 {
+			panic(errors.New("random: upper bound must be positive"))
+		}
+		m.r.write(regA0, uint32(m.random(m.r.read(regA0)).Int31n(bound)))
+	},
+	43: func(m *Machine) { // random float in [0, 1) to $f0
+		m.writeFP(cop1S, 0, float64(m.random(m.r.read(regA0)).Float32()))
+	},
+	44: func(m *Machine) { // random double in [0, 1) to $f0
+		m.writeFP(cop1D, 0, m.random(m.r.read(regA0)).Float64())
+	},
+}
+
+// CPUState is the state of the machine passed to syscall handlers
+type CPUState interface {
+	ReadReg(reg string) (uint32, error)
+	WriteReg(reg string, value uint32) error
+	ReadMemory(addr uint32) (uint32, error)
+	WriteMemory(addr uint32, value uint32) error
+	ReadString(addr uint32) (string, error)
+	// Stdin and Stdout are the standard streams of the program
+	Stdin() io.Reader
+	Stdout() io.Writer
+	// Halt exits the program with code after the syscall
+	Halt(code int)
+}
+
+type cpuState struct {
+	*Emulator
+}
+
+func (c cpuState) Stdin() io.Reader  { return &limitReader{c.machine.stdin, c.machine.limit} }
+func (c cpuState) Stdout() io.Writer { return c.machine.stdout }
+
+func (c cpuState) Halt(code int) {
+	c.machine.exit = true
+	c.machine.exitCode = code
+}
+
+// RegisterSyscall makes fn handle syscall service n, replacing the
+// built-in service if there is one. An error returned by fn stops the
+// program.
+func (e *Emulator) RegisterSyscall(n int, fn func(cpu CPUState) error) {
+	e.machine.syscalls[uint32(n)] = func(m *Machine) {
+		checkInstErr(fn(cpuState{e}))
+	}
+}
+
+func systemCall(m *Machine, args ...int) {
+	f, ok := m.syscalls[m.r.read(regV0)]
+	if !ok {
+		f, ok = syscallTable[m.r.read(regV0)]
+	}
+	if !ok {
+		m.raise(EXC_SYS, 0)
+	}
+	f(m)
+}
+
+// printf writes to the standard output of the program
+func (m *Machine) printf(format string, a ...interface{}) {
+	_, err := fmt.Fprintf(m.stdout, format, a...)
+	checkInstErr(err)
+}
+
+// readLine reads a line from the standard input of the program, as the
+// read syscalls of SPIM consume whole lines. The line ending and
+// surrounding spaces are removed.
+func (m *Machine) readLine() string {
+	s, err := m.stdin.ReadString('\n')
+	m.limit.read(len(s))
+	if err != io.EOF || s == "" {
+		checkInstErr(err)
+	}
+	return strings.TrimSpace(s)
+}
+
+// loadString reads the null-terminated string at addr
+func (m *Machine) loadString(addr uint32) string {
+	var buf []byte
+	for b := m.loadByte(addr); b != 0; b = m.loadByte(addr) {
+		buf = append(buf, b)
+		addr++
+	}
+	return string(buf)
+}
+
+// openFile opens name in the file system of the program with MARS
+// flags and returns its descriptor, or -1
+func (m *Machine) openFile(name string, flags uint32) int32 {
+	var mode int
+	switch flags {
+	case openRead:
+		mode = os.O_RDONLY
+	case openWrite:
+		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case openAppend:
+		mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	default:
+		return -1
+	}
+	f, err := m.fs.Open(name, mode)
+	if err != nil {
+		return -1
+	}
+	fd := uint32(3)
+	for m.files[fd] != nil {
+		fd++
+	}
+	m.files[fd] = f
+	return int32(fd)
+}
+
+// random returns generator id, seeding it with the time if it is new
+func (m *Machine) random(id uint32) *rand.Rand {
+	r, ok := m.rands[id]
+	if !ok {
+		r = rand.New(rand.NewSource(time.Now().UnixNano()))
+		m.rands[id] = r
+	}
+	return r
+}
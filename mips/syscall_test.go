@@ -0,0 +1,381 @@
+package mips
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSbrk(t *testing.T) {
+	em := NewEmulator()
+	if err := em.LoadAndRun(assembleString(t, `.data
+x:	.byte 1, 2, 3
+.text
+main:
+	li $a0, 5
+	li $v0, 9
+	syscall
+	move $s0, $v0
+	li $a0, 4
+	li $v0, 9
+	syscall
+	move $s1, $v0
+	sw $s1, 0($s1)
+	li $v0, 10
+	syscall`)); err != nil {
+		t.Fatal(err)
+	}
+	for reg, want := range map[string]uint32{
+		"s0": DATA_ADDRESS + 8,
+		"s1": DATA_ADDRESS + 16,
+	} {
+		if v, _ := em.ReadReg(reg); v != want {
+			t.Errorf("expect $%s = %#x, got %#x", reg, want, v)
+		}
+	}
+	if start, brk := em.Heap(); start != DATA_ADDRESS+8 || brk != DATA_ADDRESS+20 {
+		t.Errorf("expect heap %#x - %#x, got %#x - %#x",
+			DATA_ADDRESS+8, DATA_ADDRESS+20, start, brk)
+	}
+}
+
+func TestHeapLimit(t *testing.T) {
+	tests := []struct {
+		src string
+		err bool
+	}{
+		{"li $a0, 64\n\tli $v0, 9\n\tsyscall", false},
+		{"li $a0, 65\n\tli $v0, 9\n\tsyscall", true},
+		// beyond the break
+		{"lui $t0, 0x400\n\tsw $zero, 0($t0)", true},
+		{"li $a0, 4\n\tli $v0, 9\n\tsyscall\n\tsw $zero, 0($v0)", false},
+	}
+	for _, tt := range tests {
+		em := NewEmulator(WithHeapLimit(64))
+		err := em.LoadAndRun(assembleString(t, "main:\n\t"+tt.src+"\n\tli $v0, 10\n\tsyscall"))
+		if (err != nil) != tt.err {
+			t.Errorf("%q: expect error %v, got %v", tt.src, tt.err, err)
+		}
+	}
+}
+
+func TestExit2(t *testing.T) {
+	em := NewEmulator()
+	if err := em.LoadAndRun(assembleString(t,
+		"main:\n\tli $a0, 3\n\tli $v0, 17\n\tsyscall\n\tli $a0, 4")); err != nil {
+		t.Fatal(err)
+	}
+	if em.ExitCode() != 3 {
+		t.Errorf("expect exit code 3, got %d", em.ExitCode())
+	}
+}
+
+func TestSleep(t *testing.T) {
+	// sleeps for an hour unless stopped
+	raw := assembleString(t, `.text
+main:
+	li $a0, 3600000
+	li $v0, 32
+	syscall
+	li $v0, 10
+	syscall`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	em := NewEmulator()
+	if err := em.Load(raw); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := em.Run(ctx); status != EXIT_TIMEOUT || err != context.DeadlineExceeded {
+		t.Errorf("expect the deadline to stop the sleep, got %s(%v)", status, err)
+	}
+
+	em = NewEmulator()
+	if err := em.Load(raw); err != nil {
+		t.Fatal(err)
+	}
+	em.SetTimer(10 * time.Millisecond)
+	if status, _ := em.Run(context.Background()); status != EXIT_TIMEOUT {
+		t.Errorf("expect the timer to stop the sleep, got %s", status)
+	}
+}
+
+func TestFileSyscalls(t *testing.T) {
+	fs := NewMemFS(nil, ReadWrite)
+	name := "/tmp/../out.txt"
+
+	// Write a line, append another one, then read the file back
+	em := NewEmulator(WithFileSystem(fs))
+	if err := em.LoadAndRun(assembleString(t, `.data
+buf:	.space 32
+msg:	.ascii "hello"
+	.byte 10
+name:	.asciiz "`+name+`"
+.text
+main:
+	la $a0, name
+	li $a1, 1
+	jal write
+	la $a0, name
+	li $a1, 9
+	jal write
+	la $a0, name
+	li $a1, 0
+	li $v0, 13
+	syscall
+	move $s0, $v0
+	move $a0, $s0
+	la $a1, buf
+	li $a2, 32
+	li $v0, 14
+	syscall
+	move $s1, $v0
+	move $a0, $s0
+	li $v0, 14
+	syscall
+	move $s2, $v0
+	move $a0, $s0
+	li $v0, 16
+	syscall
+	li $a0, 42
+	li $v0, 15
+	syscall
+	move $s3, $v0
+	li $v0, 10
+	syscall
+write:
+	li $v0, 13
+	syscall
+	move $t0, $v0
+	move $a0, $t0
+	la $a1, msg
+	li $a2, 6
+	li $v0, 15
+	syscall
+	move $a0, $t0
+	li $v0, 16
+	syscall
+	jr $ra`)); err != nil {
+		t.Fatal(err)
+	}
+	if b := fs.Files["out.txt"]; string(b) != "hello\nhello\n" {
+		t.Errorf("expect two lines in the file, got %q", b)
+	}
+	for reg, want := range map[string]uint32{
+		"s0": 3,
+		"s1": 12,
+		"s2": 0,
+		"s3": 0xFFFFFFFF,
+	} {
+		if v, _ := em.ReadReg(reg); v != want {
+			t.Errorf("expect $%s = %#x, got %#x", reg, want, v)
+		}
+	}
+	if v, _ := em.ReadMemory(DATA_ADDRESS + 4); v != 0x65680A6F {
+		t.Errorf("expect the file to be read into buf, got %#x", v)
+	}
+}
+
+func TestFileSyscallChunks(t *testing.T) {
+	// Write more than a chunk, then read it back with a huge length
+	fs := NewMemFS(nil, ReadWrite)
+	em := NewEmulator(WithFileSystem(fs))
+	if err := em.LoadAndRun(assembleString(t, `.data
+name:	.asciiz "big"
+	.align 2
+buf:	.space 10000
+.text
+main:
+	la $a0, name
+	li $a1, 1
+	li $v0, 13
+	syscall
+	move $a0, $v0
+	la $a1, buf
+	li $a2, 10000
+	li $v0, 15
+	syscall
+	move $s0, $v0
+	li $v0, 16
+	syscall
+	la $a0, name
+	li $a1, 0
+	li $v0, 13
+	syscall
+	move $a0, $v0
+	la $a1, buf
+	li $a2, 0x7FFFFFFF
+	li $v0, 14
+	syscall
+	move $s1, $v0
+	li $v0, 10
+	syscall`)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fs.Files["big"]); n != 10000 {
+		t.Errorf("expect 10000 bytes written, got %d", n)
+	}
+	for reg, want := range map[string]uint32{"s0": 10000, "s1": 10000} {
+		if v, _ := em.ReadReg(reg); v != want {
+			t.Errorf("expect $%s = %d, got %d", reg, want, v)
+		}
+	}
+}
+
+func TestRandomSeed(t *testing.T) {
+	src := `.text
+main:
+	li $a0, 1
+	li $a1, 12345
+	li $v0, 40
+	syscall
+	li $v0, 41
+	syscall
+	move $s0, $a0
+	li $a0, 1
+	li $a1, 10
+	li $v0, 42
+	syscall
+	move $s1, $a0
+	li $a0, 1
+	li $v0, 44
+	syscall
+	li $v0, 10
+	syscall`
+	var results [2][3]uint32
+	for i := range results {
+		em := NewEmulator()
+		if err := em.LoadAndRun(assembleString(t, src)); err != nil {
+			t.Fatal(err)
+		}
+		for j, reg := range []string{"s0", "s1", "f1"} {
+			results[i][j], _ = em.ReadReg(reg)
+		}
+	}
+	if results[0] != results[1] {
+		t.Errorf("expect the same numbers from the same seed, got %v", results)
+	}
+	if results[0][1] >= 10 {
+		t.Errorf("expect a number below 10, got %d", results[0][1])
+	}
+}
+
+func TestConsole(t *testing.T) {
+	in := strings.NewReader(" -42 \nhello world\n3.5\n")
+	out := new(bytes.Buffer)
+	em := NewEmulator(WithStdin(in), WithStdout(out))
+	if err := em.LoadAndRun(assembleString(t, `.data
+buf:	.space 16
+.text
+main:
+	li $v0, 5
+	syscall
+	move $s0, $v0
+	la $a0, buf
+	li $a1, 8
+	li $v0, 8
+	syscall
+	li $v0, 12
+	syscall
+	move $s1, $v0
+	la $a0, buf
+	li $a1, 16
+	li $v0, 8
+	syscall
+	li $v0, 6
+	syscall
+	move $a0, $s0
+	li $v0, 1
+	syscall
+	li $a0, 32
+	li $v0, 11
+	syscall
+	li $v0, 34
+	syscall
+	li $a0, 5
+	li $v0, 35
+	syscall
+	li $a0, -1
+	li $v0, 36
+	syscall
+	la $a0, buf
+	li $v0, 4
+	syscall
+	li $v0, 10
+	syscall`)); err != nil {
+		t.Fatal(err)
+	}
+	const want = "-42 0x00000020" + "00000000000000000000000000000101" +
+		"4294967295" + "rld\n"
+	if out.String() != want {
+		t.Errorf("expect output %q, got %q", want, out.String())
+	}
+	if v, _ := em.ReadReg("s1"); v != 'o' {
+		t.Errorf("expect to read 'o', got %q", rune(v))
+	}
+	if v, _ := em.ReadReg("f0"); math.Float32frombits(v) != 3.5 {
+		t.Errorf("expect to read 3.5, got %v", math.Float32frombits(v))
+	}
+	// both strings read are NUL-terminated
+	if v, _ := em.ReadMemory(DATA_ADDRESS + 4); v != 0x00772000 {
+		t.Errorf("expect \"\\x00 w\\x00\" in buf, got %#x", v)
+	}
+}
+
+func TestRegisterSyscall(t *testing.T) {
+	src := `.data
+msg:	.asciiz "ok"
+.text
+main:
+	la $a0, msg
+	li $v0, 100
+	syscall
+	move $a0, $v0
+	li $v0, 1
+	syscall
+	li $v0, 101
+	syscall
+	li $s0, 1`
+	out := new(bytes.Buffer)
+	em := NewEmulator(WithStdout(out))
+	em.RegisterSyscall(100, func(cpu CPUState) error {
+		a0, _ := cpu.ReadReg("a0")
+		s, err := cpu.ReadString(a0)
+		if err != nil {
+			return err
+		}
+		return cpu.WriteReg("v0", uint32(len(s)))
+	})
+	// override a built-in service
+	em.RegisterSyscall(1, func(cpu CPUState) error {
+		a0, _ := cpu.ReadReg("a0")
+		_, err := fmt.Fprintf(cpu.Stdout(), "<%d>", a0)
+		return err
+	})
+	em.RegisterSyscall(101, func(cpu CPUState) error {
+		cpu.Halt(7)
+		return nil
+	})
+	if err := em.LoadAndRun(assembleString(t, src)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "<2>" {
+		t.Errorf("expect output <2>, got %q", out.String())
+	}
+	if v, _ := em.ReadReg("s0"); v != 0 || em.ExitCode() != 7 {
+		t.Errorf("expect to halt with code 7, got %d", em.ExitCode())
+	}
+
+	em = NewEmulator()
+	em.RegisterSyscall(100, func(cpu CPUState) error {
+		return errors.New("assertion failed")
+	})
+	err := em.LoadAndRun(assembleString(t, src))
+	if err == nil || err.Error() != "assertion failed" {
+		t.Errorf("expect the error of the handler, got %v", err)
+	}
+}
@@ -1,5 +1,10 @@
 package mips
 
+import (
+	"fmt"
+	"strconv"
+)
+
 /*
 instruction format:
 
@@ -19,15 +24,24 @@ type instInfo struct {
 	formats []fmtType
 	opcode  int
 	funct   int
+	rs      int // fixed rs field of coprocessor instructions and rotr
+	rt      int // fixed rt field of REGIMM instructions
+	shamt   int // fixed shamt field of rotrv and BSHFL instructions
 	size    int
+	branch  bool // has a branch delay slot
+	load    bool // has a load delay slot
 }
 
 const (
 	// argument type
-	argReg     argType = 1 << iota // register
-	argInteger                     // immediate integer
-	argLabel                       // label
-	argAddr                        // format of address is C($s)
+	argReg      argType = 1 << iota // register
+	argInteger                      // immediate integer
+	argLabel                        // label
+	argAddr                         // format of address is C($s)
+	argCReg                         // coprocessor register, by number
+	argOptional                     // may be omitted with the arguments after it
+	argFReg                         // floating-point register
+	argFloat                        // floating-point constant
 	// format type
 	fmtRegD fmtType = 1 << iota
 	fmtRegS
@@ -35,6 +49,10 @@ const (
 	fmtShamt
 	fmtImmediate
 	fmtAddress
+	fmtExtSize // size of ext, encoded as size-1 in rd
+	fmtInsSize // size of ins, encoded as pos+size-1 in rd
+	fmtFd      // fd of floating-point instructions, in the shamt field
+	fmtCode    // code of trap instructions, in bits 6 to 15
 )
 
 var (
@@ -102,6 +120,27 @@ var (
 			opcode:  0,
 			funct:   0x2A,
 		},
+		"sltu": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argReg},
+			formats: []fmtType{fmtRegD, fmtRegS, fmtRegT},
+			opcode:  0,
+			funct:   0x2B,
+		},
+		"movz": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argReg},
+			formats: []fmtType{fmtRegD, fmtRegS, fmtRegT},
+			opcode:  0,
+			funct:   0xA,
+		},
+		"movn": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argReg},
+			formats: []fmtType{fmtRegD, fmtRegS, fmtRegT},
+			opcode:  0,
+			funct:   0xB,
+		},
 		"sllv": instInfo{
 			typ:     "R",
 			syntax:  []argType{argReg, argReg, argReg},
@@ -123,6 +162,14 @@ var (
 			opcode:  0,
 			funct:   0x7,
 		},
+		"rotrv": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argReg},
+			formats: []fmtType{fmtRegD, fmtRegT, fmtRegS},
+			opcode:  0,
+			funct:   0x6,
+			shamt:   0x1,
+		},
 		// R2
 		"sll": instInfo{
 			typ:     "R",
@@ -145,6 +192,14 @@ var (
 			opcode:  0,
 			funct:   0x3,
 		},
+		"rotr": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argInteger},
+			formats: []fmtType{fmtRegD, fmtRegT, fmtShamt},
+			opcode:  0,
+			funct:   0x2,
+			rs:      0x1,
+		},
 		// R3
 		"mult": instInfo{
 			typ:     "R",
@@ -189,6 +244,20 @@ var (
 			opcode:  0,
 			funct:   0x12,
 		},
+		"mthi": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg},
+			formats: []fmtType{fmtRegS},
+			opcode:  0,
+			funct:   0x11,
+		},
+		"mtlo": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg},
+			formats: []fmtType{fmtRegS},
+			opcode:  0,
+			funct:   0x13,
+		},
 		// R5
 		"jr": instInfo{
 			typ:     "R",
@@ -196,6 +265,16 @@ var (
 			formats: []fmtType{fmtRegS},
 			opcode:  0,
 			funct:   0x8,
+			branch:  true,
+		},
+		// jalr rs is short for jalr $ra, rs
+		"jalr": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg | argOptional},
+			formats: []fmtType{fmtRegD, fmtRegS},
+			opcode:  0,
+			funct:   0x9,
+			branch:  true,
 		},
 		// R6
 		"syscall": instInfo{
@@ -205,6 +284,175 @@ var (
 			opcode:  0,
 			funct:   0xC,
 		},
+		"break": instInfo{
+			typ:     "R",
+			syntax:  []argType{},
+			formats: []fmtType{},
+			opcode:  0,
+			funct:   0xD,
+		},
+		// traps, with an optional code
+		"tge": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argInteger | argOptional},
+			formats: []fmtType{fmtRegS, fmtRegT, fmtCode},
+			opcode:  0,
+			funct:   0x30,
+		},
+		"tgeu": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argInteger | argOptional},
+			formats: []fmtType{fmtRegS, fmtRegT, fmtCode},
+			opcode:  0,
+			funct:   0x31,
+		},
+		"tlt": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argInteger | argOptional},
+			formats: []fmtType{fmtRegS, fmtRegT, fmtCode},
+			opcode:  0,
+			funct:   0x32,
+		},
+		"tltu": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argInteger | argOptional},
+			formats: []fmtType{fmtRegS, fmtRegT, fmtCode},
+			opcode:  0,
+			funct:   0x33,
+		},
+		"teq": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argInteger | argOptional},
+			formats: []fmtType{fmtRegS, fmtRegT, fmtCode},
+			opcode:  0,
+			funct:   0x34,
+		},
+		"tne": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argInteger | argOptional},
+			formats: []fmtType{fmtRegS, fmtRegT, fmtCode},
+			opcode:  0,
+			funct:   0x36,
+		},
+		// SPECIAL2
+		"mul": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argReg},
+			formats: []fmtType{fmtRegD, fmtRegS, fmtRegT},
+			opcode:  0x1C,
+			funct:   0x2,
+		},
+		"madd": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg},
+			formats: []fmtType{fmtRegS, fmtRegT},
+			opcode:  0x1C,
+			funct:   0x0,
+		},
+		"maddu": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg},
+			formats: []fmtType{fmtRegS, fmtRegT},
+			opcode:  0x1C,
+			funct:   0x1,
+		},
+		"msub": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg},
+			formats: []fmtType{fmtRegS, fmtRegT},
+			opcode:  0x1C,
+			funct:   0x4,
+		},
+		"msubu": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg},
+			formats: []fmtType{fmtRegS, fmtRegT},
+			opcode:  0x1C,
+			funct:   0x5,
+		},
+		"clz": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg},
+			formats: []fmtType{fmtRegD, fmtRegS},
+			opcode:  0x1C,
+			funct:   0x20,
+		},
+		"clo": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg},
+			formats: []fmtType{fmtRegD, fmtRegS},
+			opcode:  0x1C,
+			funct:   0x21,
+		},
+		// SPECIAL3
+		"ext": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argInteger, argInteger},
+			formats: []fmtType{fmtRegT, fmtRegS, fmtShamt, fmtExtSize},
+			opcode:  0x1F,
+			funct:   0x0,
+		},
+		"ins": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg, argInteger, argInteger},
+			formats: []fmtType{fmtRegT, fmtRegS, fmtShamt, fmtInsSize},
+			opcode:  0x1F,
+			funct:   0x4,
+		},
+		"wsbh": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg},
+			formats: []fmtType{fmtRegD, fmtRegT},
+			opcode:  0x1F,
+			funct:   0x20,
+			shamt:   0x02,
+		},
+		"seb": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg},
+			formats: []fmtType{fmtRegD, fmtRegT},
+			opcode:  0x1F,
+			funct:   0x20,
+			shamt:   0x10,
+		},
+		"seh": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argReg},
+			formats: []fmtType{fmtRegD, fmtRegT},
+			opcode:  0x1F,
+			funct:   0x20,
+			shamt:   0x18,
+		},
+		"rdhwr": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argCReg},
+			formats: []fmtType{fmtRegT, fmtRegD},
+			opcode:  0x1F,
+			funct:   0x3B,
+		},
+		// COP0
+		"mfc0": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argCReg},
+			formats: []fmtType{fmtRegT, fmtRegD},
+			opcode:  0x10,
+			rs:      0x0,
+		},
+		"mtc0": instInfo{
+			typ:     "R",
+			syntax:  []argType{argReg, argCReg},
+			formats: []fmtType{fmtRegT, fmtRegD},
+			opcode:  0x10,
+			rs:      0x4,
+		},
+		"eret": instInfo{
+			typ:     "R",
+			syntax:  []argType{},
+			formats: []fmtType{},
+			opcode:  0x10,
+			rs:      0x10,
+			funct:   0x18,
+		},
 		// I1
 		"addi": instInfo{
 			typ:     "I",
@@ -230,24 +478,128 @@ var (
 			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
 			opcode:  0xD,
 		},
+		"xori": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argReg, argInteger},
+			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
+			opcode:  0xE,
+		},
 		"slti": instInfo{
 			typ:     "I",
 			syntax:  []argType{argReg, argReg, argInteger},
 			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
 			opcode:  0xA,
 		},
+		"sltiu": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argReg, argInteger},
+			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
+			opcode:  0xB,
+		},
 		// I2
 		"bne": instInfo{
 			typ:     "I",
 			syntax:  []argType{argReg, argReg, argInteger | argLabel},
 			formats: []fmtType{fmtRegS, fmtRegT, fmtImmediate},
 			opcode:  0x5,
+			branch:  true,
 		},
 		"beq": instInfo{
 			typ:     "I",
 			syntax:  []argType{argReg, argReg, argInteger | argLabel},
 			formats: []fmtType{fmtRegS, fmtRegT, fmtImmediate},
 			opcode:  0x4,
+			branch:  true,
+		},
+		"blez": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger | argLabel},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x6,
+			branch:  true,
+		},
+		"bgtz": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger | argLabel},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x7,
+			branch:  true,
+		},
+		// REGIMM, selected by rt
+		"bltz": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger | argLabel},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x1,
+			rt:      0x0,
+			branch:  true,
+		},
+		"bgez": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger | argLabel},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x1,
+			rt:      0x1,
+			branch:  true,
+		},
+		"bltzal": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger | argLabel},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x1,
+			rt:      0x10,
+			branch:  true,
+		},
+		"bgezal": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger | argLabel},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x1,
+			rt:      0x11,
+			branch:  true,
+		},
+		// REGIMM traps
+		"tgei": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x1,
+			rt:      0x08,
+		},
+		"tgeiu": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x1,
+			rt:      0x09,
+		},
+		"tlti": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x1,
+			rt:      0x0A,
+		},
+		"tltiu": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x1,
+			rt:      0x0B,
+		},
+		"teqi": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x1,
+			rt:      0x0C,
+		},
+		"tnei": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argInteger},
+			formats: []fmtType{fmtRegS, fmtImmediate},
+			opcode:  0x1,
+			rt:      0x0E,
 		},
 		// I3
 		"lw": instInfo{
@@ -255,30 +607,56 @@ var (
 			syntax:  []argType{argReg, argAddr},
 			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
 			opcode:  0x23,
+			load:    true,
 		},
 		"lh": instInfo{
 			typ:     "I",
 			syntax:  []argType{argReg, argAddr},
 			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
 			opcode:  0x21,
+			load:    true,
 		},
 		"lhu": instInfo{
 			typ:     "I",
 			syntax:  []argType{argReg, argAddr},
 			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
 			opcode:  0x25,
+			load:    true,
 		},
 		"lb": instInfo{
 			typ:     "I",
 			syntax:  []argType{argReg, argAddr},
 			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
 			opcode:  0x20,
+			load:    true,
 		},
 		"lbu": instInfo{
 			typ:     "I",
 			syntax:  []argType{argReg, argAddr},
 			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
 			opcode:  0x24,
+			load:    true,
+		},
+		"lwl": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argAddr},
+			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
+			opcode:  0x22,
+			load:    true,
+		},
+		"lwr": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argAddr},
+			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
+			opcode:  0x26,
+			load:    true,
+		},
+		"ll": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argAddr},
+			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
+			opcode:  0x30,
+			load:    true,
 		},
 		"sw": instInfo{
 			typ:     "I",
@@ -298,6 +676,24 @@ var (
 			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
 			opcode:  0x28,
 		},
+		"swl": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argAddr},
+			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
+			opcode:  0x2A,
+		},
+		"swr": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argAddr},
+			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
+			opcode:  0x2E,
+		},
+		"sc": instInfo{
+			typ:     "I",
+			syntax:  []argType{argReg, argAddr},
+			formats: []fmtType{fmtRegT, fmtRegS, fmtImmediate},
+			opcode:  0x38,
+		},
 		// I4
 		"lui": instInfo{
 			typ:     "I",
@@ -311,19 +707,16 @@ var (
 			syntax:  []argType{argInteger | argLabel},
 			formats: []fmtType{fmtAddress},
 			opcode:  0x2,
+			branch:  true,
 		},
 		"jal": instInfo{
 			typ:     "J",
 			syntax:  []argType{argInteger | argLabel},
 			formats: []fmtType{fmtAddress},
 			opcode:  0x3,
+			branch:  true,
 		},
 		// Pseudo
-		"mul": instInfo{
-			typ:    "P",
-			syntax: []argType{argReg, argReg, argReg},
-			size:   2,
-		},
 		"divq": instInfo{
 			typ:    "P",
 			syntax: []argType{argReg, argReg, argReg},
@@ -338,36 +731,55 @@ var (
 			typ:    "P",
 			syntax: []argType{argReg, argReg, argInteger | argLabel},
 			size:   2,
+			branch: true,
 		},
 		"blt": instInfo{
 			typ:    "P",
 			syntax: []argType{argReg, argReg, argInteger | argLabel},
 			size:   2,
+			branch: true,
 		},
 		"bge": instInfo{
 			typ:    "P",
 			syntax: []argType{argReg, argReg, argInteger | argLabel},
 			size:   2,
+			branch: true,
 		},
 		"ble": instInfo{
 			typ:    "P",
 			syntax: []argType{argReg, argReg, argInteger | argLabel},
 			size:   2,
+			branch: true,
 		},
 		"bgtu": instInfo{
 			typ:    "P",
 			syntax: []argType{argReg, argReg, argInteger | argLabel},
 			size:   2,
+			branch: true,
 		},
-		"bgtz": instInfo{
+		"beqz": instInfo{
 			typ:    "P",
 			syntax: []argType{argReg, argInteger | argLabel},
-			size:   2,
+			size:   1,
+			branch: true,
 		},
-		"beqz": instInfo{
+		"bnez": instInfo{
 			typ:    "P",
 			syntax: []argType{argReg, argInteger | argLabel},
 			size:   1,
+			branch: true,
+		},
+		"b": instInfo{
+			typ:    "P",
+			syntax: []argType{argInteger | argLabel},
+			size:   1,
+			branch: true,
+		},
+		"bal": instInfo{
+			typ:    "P",
+			syntax: []argType{argInteger | argLabel},
+			size:   1,
+			branch: true,
 		},
 		"move": instInfo{
 			typ:    "P",
@@ -399,6 +811,36 @@ var (
 			syntax: []argType{},
 			size:   1,
 		},
+		"l.s": instInfo{
+			typ:    "P",
+			syntax: []argType{argFReg, argAddr},
+			size:   1,
+		},
+		"s.s": instInfo{
+			typ:    "P",
+			syntax: []argType{argFReg, argAddr},
+			size:   1,
+		},
+		"l.d": instInfo{
+			typ:    "P",
+			syntax: []argType{argFReg, argAddr},
+			size:   1,
+		},
+		"s.d": instInfo{
+			typ:    "P",
+			syntax: []argType{argFReg, argAddr},
+			size:   1,
+		},
+		"li.s": instInfo{
+			typ:    "P",
+			syntax: []argType{argFReg, argFloat},
+			size:   3,
+		},
+		"li.d": instInfo{
+			typ:    "P",
+			syntax: []argType{argFReg, argFloat},
+			size:   6,
+		},
 	}
 	registerNames = []string{
 		"zero", "at",
@@ -411,23 +853,92 @@ var (
 		"gp", "sp", "fp", "ra",
 	}
 	registerTable = make(map[string]int)
-	// opcode of r instrucions is 0
-	// map their function code to their name
-	rInstructions = make(map[int]string)
-	// map their opcode to their name
-	ijInstructions = make(map[int]string)
+	// floating-point registers, "f0" to "f31"
+	fpRegisterTable = make(map[string]int)
+	// map the decode key of machine instructions to their name
+	decodeTable = make(map[uint32]string)
 )
 
 func init() {
+	addFPUInstructions()
 	for i, r := range registerNames {
 		registerTable[r] = i
+		registerTable[strconv.Itoa(i)] = i
 	}
 	for name, inst := range instructionTable {
-		switch inst.typ {
-		case "R":
-			rInstructions[inst.funct] = name
-		case "I", "J":
-			ijInstructions[inst.opcode] = name
+		if inst.typ != "P" {
+			decodeTable[decodeKey(inst.encoding())] = name
 		}
 	}
 }
+
+// regNumber returns the number of register name, such as "$t0" or "$f2"
+func regNumber(name string) int {
+	if n, ok := fpRegisterTable[name[1:]]; ok {
+		return n
+	}
+	return registerTable[name[1:]]
+}
+
+// encoding returns the fixed bits of the instruction's machine code
+func (inst instInfo) encoding() uint32 {
+	raw := uint32(inst.opcode<<26 | inst.rs<<21 | inst.rt<<16 | inst.shamt<<6)
+	if inst.typ == "R" {
+		raw |= uint32(inst.funct)
+	}
+	return raw
+}
+
+// decodeKey keeps the bits of raw that select an instruction: the
+// opcode, plus the funct, rs, rt or shamt field for opcodes shared by
+// several.
+func decodeKey(raw uint32) uint32 {
+	switch raw >> 26 {
+	case 0x0: // SPECIAL, selected by funct, and by the R bit for rotations
+		switch raw & 0x3F {
+		case 0x2: // srl or rotr
+			return raw & 0xFC20003F
+		case 0x6: // srlv or rotrv
+			return raw & 0xFC00007F
+		}
+		return raw & 0xFC00003F
+	case 0x1: // REGIMM, selected by rt
+		return raw & 0xFC1F0000
+	case 0x10: // COP0, selected by rs, and by funct if CO is set
+		if raw&(1<<25) != 0 {
+			return raw & 0xFFE0003F
+		}
+		return raw & 0xFFE00000
+	case 0x11: // COP1, selected by fmt, then by funct or tf. Only
+		// condition code 0 is implemented, so the cc field of compares
+		// and branches, and the nd bit, must be zero.
+		switch raw >> 21 & 0x1F {
+		case cop1S, cop1D, cop1W:
+			if raw&0x30 == 0x30 { // c.cond.fmt
+				return raw & 0xFFE007FF
+			}
+			return raw & 0xFFE0003F
+		case cop1BC:
+			return raw & 0xFFFF0000
+		}
+		return raw & 0xFFE00000
+	case 0x1C: // SPECIAL2, selected by funct
+		return raw & 0xFC00003F
+	case 0x1F: // SPECIAL3, selected by funct, and by shamt for BSHFL
+		if raw&0x3F == 0x20 {
+			return raw & 0xFC0007FF
+		}
+		return raw & 0xFC00003F
+	default:
+		return raw & 0xFC000000
+	}
+}
+
+// lookup returns the name of the instruction encoded in raw
+func lookup(raw uint32) (string, error) {
+	name, ok := decodeTable[decodeKey(raw)]
+	if !ok {
+		return "", fmt.Errorf("unsupported instruction %#08x", raw)
+	}
+	return name, nil
+}
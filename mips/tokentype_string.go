@@ -4,24 +4,26 @@ package mips
 
 import "fmt"
 
-const _tokenType_name = "tokenErrortokenEOFtokenInstructiontokenIntegertokenRegistertokenCommatokenColontokenBytetokenStringtokenDirectivetokenLeftParenthesetokenRightParenthesetokenLabeltokenLabelDeftokenEndline"
+const _tokenType_name = "tokenErrortokenEOFtokenInstructiontokenIntegertokenRegistertokenCommatokenColontokenBytetokenStringtokenDirectivetokenLeftParenthesetokenRightParenthesetokenLabeltokenLabelDeftokenEndlinetokenFloattokenFPRegister"
 
 var _tokenType_map = map[tokenType]string{
-	2:     _tokenType_name[0:10],
-	4:     _tokenType_name[10:18],
-	8:     _tokenType_name[18:34],
-	16:    _tokenType_name[34:46],
-	32:    _tokenType_name[46:59],
-	64:    _tokenType_name[59:69],
-	128:   _tokenType_name[69:79],
-	256:   _tokenType_name[79:88],
-	512:   _tokenType_name[88:99],
-	1024:  _tokenType_name[99:113],
-	2048:  _tokenType_name[113:132],
-	4096:  _tokenType_name[132:152],
-	8192:  _tokenType_name[152:162],
-	16384: _tokenType_name[162:175],
-	32768: _tokenType_name[175:187],
+	2:      _tokenType_name[0:10],
+	4:      _tokenType_name[10:18],
+	8:      _tokenType_name[18:34],
+	16:     _tokenType_name[34:46],
+	32:     _tokenType_name[46:59],
+	64:     _tokenType_name[59:69],
+	128:    _tokenType_name[69:79],
+	256:    _tokenType_name[79:88],
+	512:    _tokenType_name[88:99],
+	1024:   _tokenType_name[99:113],
+	2048:   _tokenType_name[113:132],
+	4096:   _tokenType_name[132:152],
+	8192:   _tokenType_name[152:162],
+	16384:  _tokenType_name[162:175],
+	32768:  _tokenType_name[175:187],
+	65536:  _tokenType_name[187:197],
+	131072: _tokenType_name[197:212],
 }
 
 func (i tokenType) String() string {
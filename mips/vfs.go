@@ -0,0 +1,172 @@
+package mips
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileSystem is the file system seen by the file syscalls of a program.
+// Names are slash-separated and relative to its root; flag is a
+// combination of os.O_RDONLY, os.O_WRONLY, os.O_CREATE, os.O_TRUNC and
+// os.O_APPEND.
+type FileSystem interface {
+	Open(name string, flag int) (File, error)
+}
+
+// File is a file opened by a program
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// FSMode tells whether programs may write to a file system
+type FSMode int
+
+const (
+	ReadOnly FSMode = iota
+	ReadWrite
+)
+
+var (
+	errReadOnly = errors.New("read-only file system")
+	errNoSpace  = errors.New("no space left on file system")
+)
+
+// cleanName resolves name within the root, so that ".." can not escape
+// it. Absolute names start from the root.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func writeFlag(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+}
+
+// MemFS is a file system in memory, mapping names to contents. Files
+// written by a program are stored when they are closed.
+type MemFS struct {
+	Files map[string][]byte
+	Mode  FSMode
+	// MaxSize limits the bytes of all files, if not zero. Writes
+	// beyond it fail.
+	MaxSize int
+}
+
+// NewMemFS returns a file system holding files, which may be nil
+func NewMemFS(files map[string][]byte, mode FSMode) *MemFS {
+	if files == nil {
+		files = make(map[string][]byte)
+	}
+	return &MemFS{Files: files, Mode: mode}
+}
+
+func (fs *MemFS) Open(name string, flag int) (File, error) {
+	name = cleanName(name)
+	if writeFlag(flag) && fs.Mode != ReadWrite {
+		return nil, errReadOnly
+	}
+	data, ok := fs.Files[name]
+	if !ok && flag&os.O_CREATE == 0 || name == "" {
+		return nil, os.ErrNotExist
+	}
+	f := &memFile{fs: fs, name: name, writable: writeFlag(flag)}
+	switch {
+	case !f.writable:
+		f.r = bytes.NewReader(data)
+	case flag&os.O_APPEND != 0:
+		f.w.Write(data)
+	}
+	if !ok {
+		fs.Files[name] = nil
+	}
+	return f, nil
+}
+
+type memFile struct {
+	fs       *MemFS
+	name     string
+	writable bool
+	r        *bytes.Reader
+	w        bytes.Buffer
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		return 0, errors.New("file not open for reading")
+	}
+	return f.r.Read(p)
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if !f.writable {
+		return 0, errors.New("file not open for writing")
+	}
+	if f.fs.MaxSize != 0 && f.fs.size(f.name)+f.w.Len()+len(p) > f.fs.MaxSize {
+		return 0, errNoSpace
+	}
+	return f.w.Write(p)
+}
+
+// size returns the bytes of the files stored, except name
+func (fs *MemFS) size(except string) int {
+	n := 0
+	for name, b := range fs.Files {
+		if name != except {
+			n += len(b)
+		}
+	}
+	return n
+}
+
+func (f *memFile) Close() error {
+	if f.writable {
+		f.fs.Files[f.name] = f.w.Bytes()
+	}
+	return nil
+}
+
+// DirFS is a file system rooted at a host directory, like chroot.
+// Names, including the targets of symbolic links, can not leave Root.
+// Links to files that do not exist are refused, as creating the file
+// would follow them.
+type DirFS struct {
+	Root string
+	Mode FSMode
+}
+
+// NewDirFS returns a file system rooted at directory root
+func NewDirFS(root string, mode FSMode) *DirFS {
+	return &DirFS{Root: root, Mode: mode}
+}
+
+func (fs *DirFS) Open(name string, flag int) (File, error) {
+	if writeFlag(flag) && fs.Mode != ReadWrite {
+		return nil, errReadOnly
+	}
+	root, err := filepath.EvalSymlinks(fs.Root)
+	if err != nil {
+		return nil, err
+	}
+	name = filepath.Join(root, filepath.FromSlash(cleanName(name)))
+	// the file may not exist yet, but its directory must
+	real, err := filepath.EvalSymlinks(name)
+	if os.IsNotExist(err) {
+		if fi, err := os.Lstat(name); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return nil, os.ErrPermission
+		}
+		real, err = filepath.EvalSymlinks(filepath.Dir(name))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return nil, os.ErrPermission
+	}
+	return os.OpenFile(name, flag, 0644)
+}
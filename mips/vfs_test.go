@@ -0,0 +1,105 @@
+package mips
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemFS(t *testing.T) {
+	fs := NewMemFS(map[string][]byte{"in.txt": []byte("abc")}, ReadOnly)
+	if _, err := fs.Open("out.txt", os.O_WRONLY|os.O_CREATE); err == nil {
+		t.Error("expect writing to a read-only file system to fail")
+	}
+	if _, err := fs.Open("missing.txt", os.O_RDONLY); err == nil {
+		t.Error("expect opening a missing file to fail")
+	}
+	f, err := fs.Open("/dir/../in.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(f); err != nil || string(b) != "abc" {
+		t.Errorf("expect to read abc, got %q, %v", b, err)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("expect writing to a file opened for reading to fail")
+	}
+
+	fs.Mode = ReadWrite
+	f, err = fs.Open("in.txt", os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("def"))
+	if string(fs.Files["in.txt"]) != "abc" {
+		t.Error("expect the file to be stored when it is closed")
+	}
+	f.Close()
+	if string(fs.Files["in.txt"]) != "abcdef" {
+		t.Errorf("expect abcdef, got %q", fs.Files["in.txt"])
+	}
+
+	fs.MaxSize = 8
+	f, err = fs.Open("out.txt", os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("gh")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("i")); err == nil {
+		t.Error("expect writing beyond MaxSize to fail")
+	}
+}
+
+func TestDirFS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vmips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "secret.txt")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	escaped := filepath.Join(dir, "escaped.txt")
+	if err := os.Symlink(escaped, filepath.Join(root, "dangling.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := NewDirFS(root, ReadOnly)
+	if _, err := fs.Open("out.txt", os.O_WRONLY|os.O_CREATE); err == nil {
+		t.Error("expect writing to a read-only file system to fail")
+	}
+	if _, err := fs.Open("link.txt", os.O_RDONLY); err == nil {
+		t.Error("expect a link out of the root to be refused")
+	}
+	if _, err := fs.Open("../secret.txt", os.O_RDONLY); err == nil {
+		t.Error("expect .. not to leave the root")
+	}
+
+	fs.Mode = ReadWrite
+	f, err := fs.Open("/../out.txt", os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("out"))
+	f.Close()
+	if b, err := ioutil.ReadFile(filepath.Join(root, "out.txt")); err != nil || string(b) != "out" {
+		t.Errorf("expect out.txt in the root, got %q, %v", b, err)
+	}
+	if _, err := fs.Open("dangling.txt", os.O_WRONLY|os.O_CREATE|os.O_TRUNC); err == nil {
+		t.Error("expect a dangling link out of the root to be refused")
+	}
+	if _, err := os.Lstat(escaped); !os.IsNotExist(err) {
+		t.Errorf("expect no file created out of the root, got %v", err)
+	}
+}